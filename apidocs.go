@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// apiFileID returns the file-safe form of an API ID used for its pages and folder.
+func apiFileID(id string) string {
+	return strings.ReplaceAll(id, ":", "_")
+}
+
+// generateAPIReference writes a page for every resource, method and schema of an API
+// into the API's folder under apiDir. APIs without a discovery document are skipped.
+func generateAPIReference(tmpl *template.Template, apiDir string, api APIEntry) error {
+	doc := api.Discovery
+	if doc == nil {
+		return nil
+	}
+
+	resourceDir := filepath.Join(apiDir, api.FileName, "resource")
+	methodDir := filepath.Join(apiDir, api.FileName, "method")
+	schemaDir := filepath.Join(apiDir, api.FileName, "schema")
+	if err := os.MkdirAll(resourceDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create resource directory: %v", err)
+	}
+	if err := os.MkdirAll(methodDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create method directory: %v", err)
+	}
+	if err := os.MkdirAll(schemaDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create schema directory: %v", err)
+	}
+
+	resources := doc.FlatResources()
+	for _, resource := range resources {
+		// Methods defined directly on the API are listed on the API page instead.
+		if resource.Path != "" {
+			resourceData := struct {
+				API      APIEntry
+				Resource APIResource
+			}{
+				API:      api,
+				Resource: resource,
+			}
+			resourceFile := filepath.Join(resourceDir, resource.Path+".html")
+			if err := renderTemplate(tmpl, "resource.html", resourceFile, resourceData); err != nil {
+				return err
+			}
+		}
+
+		for _, method := range resource.Methods {
+			methodData := struct {
+				API      APIEntry
+				Method   APIMethod
+				URL      string
+				Request  *DiscoverySchema
+				Response *DiscoverySchema
+			}{
+				API:    api,
+				Method: method,
+				URL:    doc.MethodURL(method),
+			}
+			if method.Request != nil {
+				methodData.Request = doc.Schemas[method.Request.Ref]
+			}
+			if method.Response != nil {
+				methodData.Response = doc.Schemas[method.Response.Ref]
+			}
+			methodFile := filepath.Join(methodDir, method.FileName()+".html")
+			if err := renderTemplate(tmpl, "method.html", methodFile, methodData); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Index which methods use each schema as their request or response body.
+	methodsBySchema := make(map[string][]APIMethod)
+	for _, resource := range resources {
+		for _, method := range resource.Methods {
+			if method.Request != nil {
+				methodsBySchema[method.Request.Ref] = append(methodsBySchema[method.Request.Ref], method)
+			}
+			if method.Response != nil && (method.Request == nil || method.Response.Ref != method.Request.Ref) {
+				methodsBySchema[method.Response.Ref] = append(methodsBySchema[method.Response.Ref], method)
+			}
+		}
+	}
+
+	// Index which schemas refer to each schema.
+	referencedBy := make(map[string][]string)
+	for name, schema := range doc.Schemas {
+		for _, ref := range schemaRefs(schema) {
+			referencedBy[ref] = append(referencedBy[ref], name)
+		}
+	}
+
+	for name, schema := range doc.Schemas {
+		sort.Strings(referencedBy[name])
+		schemaData := struct {
+			API          APIEntry
+			Name         string
+			Schema       *DiscoverySchema
+			References   []string
+			ReferencedBy []string
+			Methods      []APIMethod
+		}{
+			API:          api,
+			Name:         name,
+			Schema:       schema,
+			References:   schemaRefs(schema),
+			ReferencedBy: referencedBy[name],
+			Methods:      methodsBySchema[name],
+		}
+		schemaFile := filepath.Join(schemaDir, name+".html")
+		if err := renderTemplate(tmpl, "schema.html", schemaFile, schemaData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderTemplate executes the named template into a newly created file.
+func renderTemplate(tmpl *template.Template, name, path string, data any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if err := tmpl.ExecuteTemplate(f, name, data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to execute %s template for %s: %v", name, path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// servicesSchemaVersion is the version of the services.json format written by the crawler.
+//
+// Version 1 was a bare JSON array of services with no metadata.
+// Version 2 wraps the services in a ServiceCatalog envelope.
+const servicesSchemaVersion = 2
+
+// serviceUsageAPI identifies the Service Usage API as the source of the services.
+const serviceUsageAPI = "serviceusage.googleapis.com"
+
+// ServiceCatalog is the envelope stored in services.json.
+type ServiceCatalog struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	CrawledAt     time.Time     `json:"crawledAt"`
+	Source        CatalogSource `json:"source"`
+	Services      []Service     `json:"services"`
+}
+
+// CatalogSource describes where the services in a catalog were crawled from.
+type CatalogSource struct {
+	API     string   `json:"api"`
+	Project string   `json:"project,omitempty"`
+	Filters []string `json:"filters,omitempty"`
+}
+
+// domainPartOverrides overrides the number of name parts used for the domain of a service.
+var domainPartOverrides = map[string]int{
+	".cloud.goog": 3,
+}
+
+// miscDomain groups the services whose name has too few parts to derive a domain from.
+const miscDomain = "misc"
+
+// nameDomain returns the domain derived from a service name, which is the last two
+// parts of it unless an override applies, or "" when the name has too few parts.
+func nameDomain(name string) string {
+	parts := strings.Split(name, ".")
+	count := 2 // default to the last two parts
+	for suffix, overrideCount := range domainPartOverrides {
+		if strings.HasSuffix(name, suffix) {
+			count = overrideCount
+			break
+		}
+	}
+	if len(parts) < count {
+		return ""
+	}
+	return strings.Join(parts[len(parts)-count:], ".")
+}
+
+// serviceDomain returns the domain a service is listed under when generating the site,
+// grouping the services without a derivable domain under "misc".
+func serviceDomain(name string) string {
+	if domain := nameDomain(name); domain != "" {
+		return domain
+	}
+	return miscDomain
+}
+
+// readServiceCatalog reads a services.json file written by any version of the crawler.
+func readServiceCatalog(path string) (*ServiceCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeServiceCatalog(data)
+}
+
+// decodeServiceCatalog parses services.json content and migrates it to the current schema.
+func decodeServiceCatalog(data []byte) (*ServiceCatalog, error) {
+	var catalog ServiceCatalog
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		// Version 1 stored the services as a bare array.
+		if err := json.Unmarshal(data, &catalog.Services); err != nil {
+			return nil, fmt.Errorf("failed to parse version 1 services: %v", err)
+		}
+		catalog.SchemaVersion = 1
+		catalog.Source = CatalogSource{API: serviceUsageAPI}
+	} else if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse services catalog: %v", err)
+	}
+
+	switch {
+	case catalog.SchemaVersion < 1:
+		return nil, fmt.Errorf("services catalog has no schema version")
+	case catalog.SchemaVersion > servicesSchemaVersion:
+		return nil, fmt.Errorf("services catalog schema version %d is newer than the supported version %d",
+			catalog.SchemaVersion, servicesSchemaVersion)
+	}
+
+	// Version 1 only recorded the domain when the crawler could derive one.
+	for i := range catalog.Services {
+		if catalog.Services[i].Domain == "" {
+			catalog.Services[i].Domain = nameDomain(catalog.Services[i].Name)
+		}
+	}
+	catalog.SchemaVersion = servicesSchemaVersion
+
+	return &catalog, nil
+}
+
+// writeServiceCatalog writes the catalog to path using the current schema version.
+func writeServiceCatalog(path string, catalog *ServiceCatalog) error {
+	catalog.SchemaVersion = servicesSchemaVersion
+
+	jsonData, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
+	if err := writeFileAtomic(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeServiceCatalog(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *ServiceCatalog
+		wantErr bool
+	}{
+		{
+			name: "version 1 bare array is migrated",
+			data: `[
+				{"name": "pubsub.googleapis.com", "title": "Cloud Pub/Sub API"},
+				{"name": "example.sandbox.googleapis.com", "title": "Example", "domain": "sandbox.googleapis.com"},
+				{"name": "localhost", "title": "No domain"}
+			]`,
+			want: &ServiceCatalog{
+				SchemaVersion: servicesSchemaVersion,
+				Source:        CatalogSource{API: serviceUsageAPI},
+				Services: []Service{
+					{Name: "pubsub.googleapis.com", Title: "Cloud Pub/Sub API", Domain: "googleapis.com"},
+					{Name: "example.sandbox.googleapis.com", Title: "Example", Domain: "sandbox.googleapis.com"},
+					{Name: "localhost", Title: "No domain"},
+				},
+			},
+		},
+		{
+			name: "version 2 envelope",
+			data: `{
+				"schemaVersion": 2,
+				"crawledAt": "2026-10-16T06:00:00Z",
+				"source": {"api": "serviceusage.googleapis.com", "project": "p1", "filters": ["state:ENABLED"]},
+				"services": [{"name": "pubsub.googleapis.com", "title": "Cloud Pub/Sub API", "state": "ENABLED", "project": "p1"}]
+			}`,
+			want: &ServiceCatalog{
+				SchemaVersion: servicesSchemaVersion,
+				CrawledAt:     time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC),
+				Source:        CatalogSource{API: serviceUsageAPI, Project: "p1", Filters: []string{"state:ENABLED"}},
+				Services: []Service{
+					{Name: "pubsub.googleapis.com", Title: "Cloud Pub/Sub API", Domain: "googleapis.com", State: "ENABLED", Project: "p1"},
+				},
+			},
+		},
+		{
+			name:    "missing schema version",
+			data:    `{"services": []}`,
+			wantErr: true,
+		},
+		{
+			name:    "newer schema version",
+			data:    `{"schemaVersion": 3, "services": []}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid version 1",
+			data:    `[{"name": 1}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			data:    `{`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeServiceCatalog([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeServiceCatalog() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeServiceCatalog() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
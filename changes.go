@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ChangeKind describes how an entry changed between two snapshots.
+type ChangeKind string
+
+const (
+	// ChangeAdded means the entry is new.
+	ChangeAdded ChangeKind = "added"
+	// ChangeRemoved means the entry is gone.
+	ChangeRemoved ChangeKind = "removed"
+	// ChangeRetitled means the title of the entry changed.
+	ChangeRetitled ChangeKind = "retitled"
+	// ChangeSummary means the documentation summary or description of the entry changed.
+	ChangeSummary ChangeKind = "summary"
+	// ChangePreferred means an API became, or stopped being, the preferred version.
+	ChangePreferred ChangeKind = "preferred"
+)
+
+// Entry types that a change applies to.
+const (
+	changeTypeService = "service"
+	changeTypeAPI     = "api"
+)
+
+// Change represents a single difference between two snapshots.
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	// Type is either "service" or "api".
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Old and New hold the previous and current value of the changed field.
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+	// Link is not saved in JSON; it is the page of the entry when it still exists.
+	Link string `json:"-"`
+	// Domain is not saved in JSON; it is the domain the entry is listed under.
+	Domain string `json:"-"`
+}
+
+// Snapshot is a compact copy of the crawl results on a single date, keeping only
+// the fields that are compared between crawls.
+type Snapshot struct {
+	SchemaVersion int                      `json:"schemaVersion"`
+	Date          string                   `json:"date"`
+	Services      map[string]SnapshotEntry `json:"services"`
+	APIs          map[string]SnapshotEntry `json:"apis"`
+}
+
+// SnapshotEntry holds the compared fields of a service or API.
+type SnapshotEntry struct {
+	Title string `json:"title"`
+	// Summary is the documentation summary of a service or the description of an API.
+	Summary   string `json:"summary,omitempty"`
+	Preferred bool   `json:"preferred,omitempty"`
+}
+
+// newSnapshot builds a snapshot of the given services and APIs.
+func newSnapshot(date string, services []Service, apis []APIEntry) *Snapshot {
+	snapshot := &Snapshot{
+		SchemaVersion: snapshotSchemaVersion,
+		Date:          date,
+		Services:      make(map[string]SnapshotEntry, len(services)),
+		APIs:          make(map[string]SnapshotEntry, len(apis)),
+	}
+	for _, svc := range services {
+		snapshot.Services[svc.Name] = SnapshotEntry{
+			Title:   svc.Title,
+			Summary: svc.Documentation,
+		}
+	}
+	for _, api := range apis {
+		snapshot.APIs[api.ID] = SnapshotEntry{
+			Title:     api.Title,
+			Summary:   api.Description,
+			Preferred: api.Preferred,
+		}
+	}
+	return snapshot
+}
+
+// diffSnapshots returns the changes from before to after, services first, then sorted by ID.
+func diffSnapshots(before, after *Snapshot) []Change {
+	changes := diffEntries(changeTypeService, before.Services, after.Services)
+	changes = append(changes, diffEntries(changeTypeAPI, before.APIs, after.APIs)...)
+	return changes
+}
+
+// diffEntries compares two sets of entries of the same type.
+func diffEntries(entryType string, before, after map[string]SnapshotEntry) []Change {
+	var changes []Change
+	for id, newEntry := range after {
+		oldEntry, ok := before[id]
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeAdded, Type: entryType, ID: id, Title: newEntry.Title})
+			continue
+		}
+		if oldEntry.Title != newEntry.Title {
+			changes = append(changes, Change{Kind: ChangeRetitled, Type: entryType, ID: id, Title: newEntry.Title,
+				Old: oldEntry.Title, New: newEntry.Title})
+		}
+		if oldEntry.Summary != newEntry.Summary {
+			changes = append(changes, Change{Kind: ChangeSummary, Type: entryType, ID: id, Title: newEntry.Title,
+				Old: oldEntry.Summary, New: newEntry.Summary})
+		}
+		if oldEntry.Preferred != newEntry.Preferred {
+			changes = append(changes, Change{Kind: ChangePreferred, Type: entryType, ID: id, Title: newEntry.Title,
+				Old: preferredLabel(oldEntry.Preferred), New: preferredLabel(newEntry.Preferred)})
+		}
+	}
+	for id, oldEntry := range before {
+		if _, ok := after[id]; !ok {
+			changes = append(changes, Change{Kind: ChangeRemoved, Type: entryType, ID: id, Title: oldEntry.Title})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].ID != changes[j].ID {
+			return changes[i].ID < changes[j].ID
+		}
+		return changes[i].Kind < changes[j].Kind
+	})
+	return changes
+}
+
+// preferredLabel describes whether an API is the preferred version.
+func preferredLabel(preferred bool) string {
+	if preferred {
+		return "preferred"
+	}
+	return "not preferred"
+}
+
+// ChangeDay holds the changes found by the crawl on a date.
+type ChangeDay struct {
+	Date    string
+	Changes []Change
+}
+
+// ChangeWeek groups the days of a week, which starts on Monday.
+type ChangeWeek struct {
+	Start string
+	Days  []ChangeDay
+}
+
+// changelogPage describes a generated changelog page and the kinds of change it lists.
+type changelogPage struct {
+	FileName    string
+	Title       string
+	Description string
+	// Kinds lists the kinds of change shown on the page, or nil for every kind.
+	Kinds []ChangeKind
+}
+
+// changelogPages are the changelog pages generated from the crawl history.
+var changelogPages = []changelogPage{
+	{
+		FileName:    "changes.html",
+		Title:       "Changes",
+		Description: "Services and APIs added, removed, retitled or whose documentation changed, by crawl date.",
+	},
+	{
+		FileName:    "new.html",
+		Title:       "What's New",
+		Description: "Services and APIs that appeared in the catalog, by crawl date.",
+		Kinds:       []ChangeKind{ChangeAdded},
+	},
+	{
+		FileName:    "removed.html",
+		Title:       "Recently Removed",
+		Description: "Services and APIs that disappeared from the catalog, by crawl date.",
+		Kinds:       []ChangeKind{ChangeRemoved},
+	},
+}
+
+// buildChangelog diffs every snapshot against the one before it, newest day first.
+// Days without changes are left out.
+func buildChangelog(snapshots []*Snapshot) []ChangeDay {
+	var days []ChangeDay
+	for i := len(snapshots) - 1; i > 0; i-- {
+		changes := diffSnapshots(snapshots[i-1], snapshots[i])
+		if len(changes) > 0 {
+			days = append(days, ChangeDay{Date: snapshots[i].Date, Changes: changes})
+		}
+	}
+	return days
+}
+
+// filterChangelog keeps the changes of the given kinds, dropping days left without changes.
+func filterChangelog(days []ChangeDay, kinds []ChangeKind) []ChangeDay {
+	if kinds == nil {
+		return days
+	}
+
+	var filtered []ChangeDay
+	for _, day := range days {
+		var changes []Change
+		for _, change := range day.Changes {
+			for _, kind := range kinds {
+				if change.Kind == kind {
+					changes = append(changes, change)
+					break
+				}
+			}
+		}
+		if len(changes) > 0 {
+			filtered = append(filtered, ChangeDay{Date: day.Date, Changes: changes})
+		}
+	}
+	return filtered
+}
+
+// weekStart returns the Monday of the week containing a history date.
+func weekStart(date string) string {
+	day, err := time.Parse(historyDateFormat, date)
+	if err != nil {
+		return date
+	}
+	offset := (int(day.Weekday()) + 6) % 7
+	return historyDate(day.AddDate(0, 0, -offset))
+}
+
+// groupByWeek groups days, newest first, into the weeks they belong to.
+func groupByWeek(days []ChangeDay) []ChangeWeek {
+	var weeks []ChangeWeek
+	for _, day := range days {
+		start := weekStart(day.Date)
+		if len(weeks) == 0 || weeks[len(weeks)-1].Start != start {
+			weeks = append(weeks, ChangeWeek{Start: start})
+		}
+		weeks[len(weeks)-1].Days = append(weeks[len(weeks)-1].Days, day)
+	}
+	return weeks
+}
+
+// defaultAPIDomain is the domain of an API that is not linked to a service,
+// as every API in the directory is served from googleapis.com.
+const defaultAPIDomain = "googleapis.com"
+
+// generateChangelogPages writes the changelog pages from the snapshots in the history,
+// linking each change to the page of the service or API when it still exists.
+// It returns the changelog, newest day first, so it can be reused for the feeds.
+func generateChangelogPages(tmpl *template.Template, htmlDir string, services []Service, apis []APIEntry) ([]ChangeDay, error) {
+	snapshots, err := readSnapshots(snapshotDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshots: %v", err)
+	}
+
+	links := make(map[string]string, len(services)+len(apis))
+	domains := make(map[string]string, len(services)+len(apis))
+	for _, svc := range services {
+		links[changeTypeService+":"+svc.Name] = "service/" + svc.FileName + ".html"
+		domains[changeTypeService+":"+svc.Name] = svc.Domain
+	}
+	for _, api := range apis {
+		links[changeTypeAPI+":"+api.ID] = "api/" + api.FileName + ".html"
+		if api.Service != nil {
+			domains[changeTypeAPI+":"+api.ID] = api.Service.Domain
+		}
+	}
+
+	days := buildChangelog(snapshots)
+	for _, day := range days {
+		for i, change := range day.Changes {
+			key := change.Type + ":" + change.ID
+			day.Changes[i].Link = links[key]
+			day.Changes[i].Domain = domains[key]
+			if day.Changes[i].Domain != "" {
+				continue
+			}
+			// Removed services and unlinked APIs fall back to the domain of their name.
+			if change.Type == changeTypeService {
+				day.Changes[i].Domain = serviceDomain(change.ID)
+			} else {
+				day.Changes[i].Domain = defaultAPIDomain
+			}
+		}
+	}
+
+	for _, page := range changelogPages {
+		pageData := struct {
+			Page      changelogPage
+			Pages     []changelogPage
+			Weeks     []ChangeWeek
+			Snapshots int
+		}{
+			Page:      page,
+			Pages:     changelogPages,
+			Weeks:     groupByWeek(filterChangelog(days, page.Kinds)),
+			Snapshots: len(snapshots),
+		}
+		pageFile := filepath.Join(htmlDir, page.FileName)
+		if err := renderTemplate(tmpl, "changes.html", pageFile, pageData); err != nil {
+			return nil, err
+		}
+		log.Printf("Generated changelog page: %s", pageFile)
+	}
+	return days, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testSnapshot builds a snapshot from its services and APIs.
+func testSnapshot(date string, services, apis map[string]SnapshotEntry) *Snapshot {
+	if services == nil {
+		services = map[string]SnapshotEntry{}
+	}
+	if apis == nil {
+		apis = map[string]SnapshotEntry{}
+	}
+	return &Snapshot{SchemaVersion: snapshotSchemaVersion, Date: date, Services: services, APIs: apis}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after *Snapshot
+		want          []Change
+	}{
+		{
+			name:   "unchanged",
+			before: testSnapshot("", map[string]SnapshotEntry{"a.googleapis.com": {Title: "A"}}, nil),
+			after:  testSnapshot("", map[string]SnapshotEntry{"a.googleapis.com": {Title: "A"}}, nil),
+			want:   nil,
+		},
+		{
+			name:   "services added and removed",
+			before: testSnapshot("", map[string]SnapshotEntry{"b.googleapis.com": {Title: "B"}}, nil),
+			after:  testSnapshot("", map[string]SnapshotEntry{"a.googleapis.com": {Title: "A"}}, nil),
+			want: []Change{
+				{Kind: ChangeAdded, Type: changeTypeService, ID: "a.googleapis.com", Title: "A"},
+				{Kind: ChangeRemoved, Type: changeTypeService, ID: "b.googleapis.com", Title: "B"},
+			},
+		},
+		{
+			name:   "service retitled and documented",
+			before: testSnapshot("", map[string]SnapshotEntry{"a.googleapis.com": {Title: "Old A", Summary: "Old"}}, nil),
+			after:  testSnapshot("", map[string]SnapshotEntry{"a.googleapis.com": {Title: "A", Summary: "New"}}, nil),
+			want: []Change{
+				{Kind: ChangeRetitled, Type: changeTypeService, ID: "a.googleapis.com", Title: "A", Old: "Old A", New: "A"},
+				{Kind: ChangeSummary, Type: changeTypeService, ID: "a.googleapis.com", Title: "A", Old: "Old", New: "New"},
+			},
+		},
+		{
+			name: "services come before APIs",
+			before: testSnapshot("", nil, map[string]SnapshotEntry{
+				"a:v1": {Title: "A API", Preferred: true},
+				"a:v2": {Title: "A API"},
+			}),
+			after: testSnapshot("", map[string]SnapshotEntry{"z.googleapis.com": {Title: "Z"}}, map[string]SnapshotEntry{
+				"a:v1": {Title: "A API"},
+				"a:v2": {Title: "A API", Preferred: true},
+			}),
+			want: []Change{
+				{Kind: ChangeAdded, Type: changeTypeService, ID: "z.googleapis.com", Title: "Z"},
+				{Kind: ChangePreferred, Type: changeTypeAPI, ID: "a:v1", Title: "A API", Old: "preferred", New: "not preferred"},
+				{Kind: ChangePreferred, Type: changeTypeAPI, ID: "a:v2", Title: "A API", Old: "not preferred", New: "preferred"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffSnapshots(tt.before, tt.after)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffSnapshots() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildChangelog(t *testing.T) {
+	first := testSnapshot("2026-10-14", map[string]SnapshotEntry{"a.googleapis.com": {Title: "A"}}, nil)
+	same := testSnapshot("2026-10-15", map[string]SnapshotEntry{"a.googleapis.com": {Title: "A"}}, nil)
+	added := testSnapshot("2026-10-16", map[string]SnapshotEntry{
+		"a.googleapis.com": {Title: "A"},
+		"b.googleapis.com": {Title: "B"},
+	}, nil)
+	removed := testSnapshot("2026-10-17", map[string]SnapshotEntry{"a.googleapis.com": {Title: "Renamed A"}}, nil)
+
+	tests := []struct {
+		name      string
+		snapshots []*Snapshot
+		want      []ChangeDay
+	}{
+		{
+			name: "no snapshots",
+			want: nil,
+		},
+		{
+			name:      "a single snapshot has nothing to compare",
+			snapshots: []*Snapshot{first},
+			want:      nil,
+		},
+		{
+			name:      "newest day first without unchanged days",
+			snapshots: []*Snapshot{first, same, added},
+			want: []ChangeDay{
+				{Date: "2026-10-16", Changes: []Change{{Kind: ChangeAdded, Type: changeTypeService, ID: "b.googleapis.com", Title: "B"}}},
+			},
+		},
+		{
+			name:      "every changed day",
+			snapshots: []*Snapshot{first, same, added, removed},
+			want: []ChangeDay{
+				{Date: "2026-10-17", Changes: []Change{
+					{Kind: ChangeRetitled, Type: changeTypeService, ID: "a.googleapis.com", Title: "Renamed A", Old: "A", New: "Renamed A"},
+					{Kind: ChangeRemoved, Type: changeTypeService, ID: "b.googleapis.com", Title: "B"},
+				}},
+				{Date: "2026-10-16", Changes: []Change{{Kind: ChangeAdded, Type: changeTypeService, ID: "b.googleapis.com", Title: "B"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildChangelog(tt.snapshots)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildChangelog() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
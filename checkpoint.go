@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointDir holds the progress of an unfinished crawl so -resume can continue it.
+const checkpointDir = ".crawl-checkpoint"
+
+// checkpointFile is the file in checkpointDir recording the progress.
+const checkpointFile = "checkpoint.json"
+
+// checkpointBaselineFile is the file in checkpointDir holding the catalog from before the
+// crawl started, which the webhooks are told the changes against.
+const checkpointBaselineFile = "baseline.json"
+
+// checkpointOutputDir is the folder in checkpointDir the sources write their output to
+// until the crawl publishes it.
+const checkpointOutputDir = "output"
+
+// checkpointSchemaVersion is the version of the checkpoint format.
+//
+// Version 2 stages the output of the sources in checkpointOutputDir.
+const checkpointSchemaVersion = 2
+
+// CrawlCheckpoint records what an unfinished crawl completed. The services of each
+// Service Usage page are kept in their own file next to it, so the checkpoint itself
+// stays small enough to save after every page and document. The output of the sources
+// is staged next to it as well and only published once every source succeeded.
+type CrawlCheckpoint struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	StartedAt     time.Time `json:"startedAt"`
+	Project       string    `json:"project,omitempty"`
+	// Completed maps the name of each finished source to the number of entries it wrote.
+	Completed map[string]int `json:"completed"`
+	// ServiceUsage records the pages listed for each filter.
+	ServiceUsage map[string]*PageProgress `json:"serviceUsage"`
+	// Discovery lists the APIs whose discovery document has been saved.
+	Discovery map[string]bool `json:"discovery"`
+
+	dir string
+	mu  sync.Mutex
+}
+
+// PageProgress records how far a paged listing got.
+type PageProgress struct {
+	Pages         int    `json:"pages"`
+	NextPageToken string `json:"nextPageToken,omitempty"`
+	Done          bool   `json:"done"`
+}
+
+// newCheckpoint starts an empty checkpoint in dir, removing any earlier one.
+func newCheckpoint(dir, project string) (*CrawlCheckpoint, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove the previous checkpoint: %v", err)
+	}
+	checkpoint := &CrawlCheckpoint{
+		SchemaVersion: checkpointSchemaVersion,
+		StartedAt:     time.Now().UTC(),
+		Project:       project,
+		Completed:     make(map[string]int),
+		ServiceUsage:  make(map[string]*PageProgress),
+		Discovery:     make(map[string]bool),
+		dir:           dir,
+	}
+	if err := os.MkdirAll(filepath.Join(dir, checkpointOutputDir), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %v", err)
+	}
+	return checkpoint, checkpoint.save()
+}
+
+// resumeCheckpoint reads the checkpoint in dir. A missing checkpoint, or one written
+// for another project or by another version, starts a new one. So does a checkpoint
+// started more than maxAge ago, as publishing what it staged would pass off stale data
+// as freshly crawled. A maxAge of zero resumes a checkpoint of any age.
+func resumeCheckpoint(dir, project string, maxAge time.Duration) (*CrawlCheckpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if os.IsNotExist(err) {
+		log.Printf("No checkpoint to resume in %s, starting a new crawl", dir)
+		return newCheckpoint(dir, project)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+
+	var checkpoint CrawlCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %v", err)
+	}
+	if checkpoint.SchemaVersion != checkpointSchemaVersion || checkpoint.Project != project {
+		log.Printf("Warning: checkpoint in %s is for another project or version, starting a new crawl", dir)
+		return newCheckpoint(dir, project)
+	}
+	if age := time.Since(checkpoint.StartedAt); maxAge > 0 && age > maxAge {
+		log.Printf("Warning: checkpoint in %s was started %s ago, more than %s, starting a new crawl",
+			dir, age.Round(time.Minute), maxAge)
+		return newCheckpoint(dir, project)
+	}
+	if checkpoint.Completed == nil {
+		checkpoint.Completed = make(map[string]int)
+	}
+	if checkpoint.ServiceUsage == nil {
+		checkpoint.ServiceUsage = make(map[string]*PageProgress)
+	}
+	if checkpoint.Discovery == nil {
+		checkpoint.Discovery = make(map[string]bool)
+	}
+	checkpoint.dir = dir
+	log.Printf("Resuming the crawl started at %s", checkpoint.StartedAt.Format(time.RFC3339))
+	return &checkpoint, nil
+}
+
+// save writes the checkpoint. The caller must hold c.mu or be the only user.
+func (c *CrawlCheckpoint) save() error {
+	jsonData, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, checkpointFile), jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the checkpoint once the crawl has finished.
+func (c *CrawlCheckpoint) remove() error {
+	if err := os.RemoveAll(c.dir); err != nil {
+		return fmt.Errorf("failed to remove checkpoint: %v", err)
+	}
+	return nil
+}
+
+// saveBaseline records the catalog from before the crawl started.
+func (c *CrawlCheckpoint) saveBaseline(snapshot *Snapshot) error {
+	jsonData, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the baseline catalog: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(c.dir, checkpointBaselineFile), jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write the baseline catalog: %v", err)
+	}
+	return nil
+}
+
+// baseline returns the catalog recorded when the crawl started, or nil when none was.
+func (c *CrawlCheckpoint) baseline() (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, checkpointBaselineFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the baseline catalog: %v", err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse the baseline catalog: %v", err)
+	}
+	return &snapshot, nil
+}
+
+// outputPath returns where the file or folder at path in the repository is staged.
+func (c *CrawlCheckpoint) outputPath(path string) string {
+	return filepath.Join(c.dir, checkpointOutputDir, path)
+}
+
+// publish moves the staged files and folders at paths into place under root, the
+// repository unless a replay publishes elsewhere. A staged folder replaces the files
+// of the one under root, removing those it does not have. Paths that were not staged
+// are left as they are.
+func (c *CrawlCheckpoint) publish(root string, paths []string) error {
+	for _, name := range paths {
+		staged := c.outputPath(name)
+		path := filepath.Join(root, name)
+		info, err := os.Stat(staged)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read staged %s: %v", name, err)
+		}
+		if info.IsDir() {
+			if err := publishDir(staged, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create the folder of %s: %v", path, err)
+		}
+		if err := os.Rename(staged, path); err != nil {
+			return fmt.Errorf("failed to publish %s: %v", path, err)
+		}
+		log.Printf("Published %s", path)
+	}
+	return nil
+}
+
+// publishDir moves the files of the staged folder into dir and removes the files of
+// dir that were not staged.
+func publishDir(staged, dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	entries, err := os.ReadDir(staged)
+	if err != nil {
+		return fmt.Errorf("failed to list staged %s: %v", dir, err)
+	}
+	keep := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		keep[entry.Name()] = true
+		if err := os.Rename(filepath.Join(staged, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to publish %s: %v", filepath.Join(dir, entry.Name()), err)
+		}
+	}
+
+	existing, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", dir, err)
+	}
+	for _, entry := range existing {
+		if keep[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", filepath.Join(dir, entry.Name()), err)
+		}
+		log.Printf("Removed %s", filepath.Join(dir, entry.Name()))
+	}
+	log.Printf("Published %d files to %s", len(entries), dir)
+	return nil
+}
+
+// sourceDone returns the number of entries a source wrote if it already completed.
+func (c *CrawlCheckpoint) sourceDone(name string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count, ok := c.Completed[name]
+	return count, ok
+}
+
+// markSourceDone records that a source completed.
+func (c *CrawlCheckpoint) markSourceDone(name string, count int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Completed[name] = count
+	return c.save()
+}
+
+// pageProgress returns how far the listing with a filter got.
+func (c *CrawlCheckpoint) pageProgress(filter string) PageProgress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if progress, ok := c.ServiceUsage[filter]; ok {
+		return *progress
+	}
+	return PageProgress{}
+}
+
+// servicePagePath returns the file holding the services of a page listed with a filter.
+func (c *CrawlCheckpoint) servicePagePath(filter string, page int) string {
+	return filepath.Join(c.dir, fmt.Sprintf("serviceusage-%s-%d.json", urlSafe(filter), page))
+}
+
+// saveServicePage records the services of a page and the token of the page after it,
+// which is empty after the last page.
+func (c *CrawlCheckpoint) saveServicePage(filter string, page int, services []Service, nextPageToken string) error {
+	jsonData, err := json.Marshal(services)
+	if err != nil {
+		return fmt.Errorf("failed to marshal page %d: %v", page, err)
+	}
+	if err := writeFileAtomic(c.servicePagePath(filter, page), jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write page %d: %v", page, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ServiceUsage[filter] = &PageProgress{Pages: page, NextPageToken: nextPageToken, Done: nextPageToken == ""}
+	return c.save()
+}
+
+// servicePages returns the services of the pages already listed with a filter, in order.
+func (c *CrawlCheckpoint) servicePages(filter string) ([]Service, error) {
+	var services []Service
+	for page := 1; page <= c.pageProgress(filter).Pages; page++ {
+		data, err := os.ReadFile(c.servicePagePath(filter, page))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %d: %v", page, err)
+		}
+		var batch []Service
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return nil, fmt.Errorf("failed to parse page %d: %v", page, err)
+		}
+		services = append(services, batch...)
+	}
+	return services, nil
+}
+
+// documentFetched reports whether the discovery document of an API was already saved.
+func (c *CrawlCheckpoint) documentFetched(apiID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Discovery[apiID]
+}
+
+// markDocumentFetched records that the discovery document of an API was saved.
+func (c *CrawlCheckpoint) markDocumentFetched(apiID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Discovery[apiID] = true
+	return c.save()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResumeCheckpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		age     time.Duration
+		maxAge  time.Duration
+		// wantResumed is whether the earlier checkpoint is continued rather than started over.
+		wantResumed bool
+	}{
+		{name: "recent checkpoint", project: "p1", age: time.Hour, maxAge: 36 * time.Hour, wantResumed: true},
+		{name: "checkpoint from the previous run", project: "p1", age: 24 * time.Hour, maxAge: 36 * time.Hour, wantResumed: true},
+		{name: "stale checkpoint", project: "p1", age: 48 * time.Hour, maxAge: 36 * time.Hour, wantResumed: false},
+		{name: "no age limit", project: "p1", age: 30 * 24 * time.Hour, wantResumed: true},
+		{name: "another project", project: "p2", age: time.Hour, maxAge: 36 * time.Hour, wantResumed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), checkpointDir)
+			earlier, err := newCheckpoint(dir, "p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			earlier.StartedAt = time.Now().UTC().Add(-tt.age)
+			if err := earlier.markSourceDone("directory", 2); err != nil {
+				t.Fatal(err)
+			}
+			staged := earlier.outputPath("directory.json")
+			if err := os.WriteFile(staged, []byte(`{"items": []}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			checkpoint, err := resumeCheckpoint(dir, tt.project, tt.maxAge)
+			if err != nil {
+				t.Fatalf("resumeCheckpoint() error = %v", err)
+			}
+			_, done := checkpoint.sourceDone("directory")
+			_, statErr := os.Stat(staged)
+			if tt.wantResumed {
+				if !done || statErr != nil {
+					t.Errorf("checkpoint was started over, want the earlier one resumed")
+				}
+				if !checkpoint.StartedAt.Equal(earlier.StartedAt) {
+					t.Errorf("StartedAt = %s, want %s", checkpoint.StartedAt, earlier.StartedAt)
+				}
+				return
+			}
+			if done || !os.IsNotExist(statErr) {
+				t.Errorf("earlier checkpoint was resumed, want a new one without its staged files")
+			}
+			if age := time.Since(checkpoint.StartedAt); age > time.Minute {
+				t.Errorf("new checkpoint started %s ago, want now", age)
+			}
+		})
+	}
+}
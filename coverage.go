@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// coverageDomain is the domain whose services are expected to publish a discovery document.
+const coverageDomain = "googleapis.com"
+
+// CoverageReport lists where services.json and directory.json do not line up.
+type CoverageReport struct {
+	// ServiceCount is the number of services in the coverage domain.
+	ServiceCount int `json:"serviceCount"`
+	// APICount is the number of APIs in the directory.
+	APICount            int               `json:"apiCount"`
+	ServicesWithoutAPIs []CoverageService `json:"servicesWithoutApis"`
+	APIsWithoutServices []CoverageAPI     `json:"apisWithoutServices"`
+	TitleMismatches     []TitleMismatch   `json:"titleMismatches"`
+}
+
+// CoverageService represents a service in a coverage report.
+type CoverageService struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	// FileName is not saved in JSON; it is the file name of the service page.
+	FileName string `json:"-"`
+}
+
+// CoverageAPI represents an API in a coverage report.
+type CoverageAPI struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// FileName is not saved in JSON; it is the file name of the API page.
+	FileName string `json:"-"`
+}
+
+// TitleMismatch represents an API whose title differs from the title of its service.
+type TitleMismatch struct {
+	Service CoverageService `json:"service"`
+	API     CoverageAPI     `json:"api"`
+}
+
+// normalizeTitle reduces a title to the words that matter when comparing titles,
+// ignoring case and a trailing "API".
+func normalizeTitle(title string) string {
+	title = strings.ToLower(strings.TrimSpace(title))
+	title = strings.TrimSuffix(title, " api")
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// buildCoverageReport compares services and APIs that have already been joined
+// by linkServicesAndAPIs.
+func buildCoverageReport(services []Service, apis []APIEntry) CoverageReport {
+	report := CoverageReport{
+		APICount:            len(apis),
+		ServicesWithoutAPIs: []CoverageService{},
+		APIsWithoutServices: []CoverageAPI{},
+		TitleMismatches:     []TitleMismatch{},
+	}
+
+	for _, svc := range services {
+		if svc.Domain != coverageDomain {
+			continue
+		}
+		report.ServiceCount++
+		if len(svc.RelatedAPIs) == 0 {
+			report.ServicesWithoutAPIs = append(report.ServicesWithoutAPIs, CoverageService{
+				Name:     svc.Name,
+				Title:    svc.Title,
+				FileName: svc.FileName,
+			})
+		}
+	}
+
+	for _, api := range apis {
+		coverageAPI := CoverageAPI{
+			ID:       api.ID,
+			Title:    api.Title,
+			FileName: api.FileName,
+		}
+		if api.Service == nil {
+			report.APIsWithoutServices = append(report.APIsWithoutServices, coverageAPI)
+			continue
+		}
+		if normalizeTitle(api.Title) != normalizeTitle(api.Service.Title) {
+			report.TitleMismatches = append(report.TitleMismatches, TitleMismatch{
+				Service: CoverageService{
+					Name:     api.Service.Name,
+					Title:    api.Service.Title,
+					FileName: api.Service.FileName,
+				},
+				API: coverageAPI,
+			})
+		}
+	}
+	return report
+}
+
+// generateCoveragePages writes the coverage page and the same report as coverage.json.
+func generateCoveragePages(tmpl *template.Template, htmlDir string, services []Service, apis []APIEntry) error {
+	report := buildCoverageReport(services, apis)
+
+	coverageFile := filepath.Join(htmlDir, "coverage.html")
+	if err := renderTemplate(tmpl, "coverage.html", coverageFile, report); err != nil {
+		return err
+	}
+	log.Printf("Generated coverage page: %s", coverageFile)
+
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal coverage JSON: %v", err)
+	}
+	jsonFile := filepath.Join(htmlDir, "coverage.json")
+	if err := writeFileAtomic(jsonFile, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write coverage.json: %v", err)
+	}
+	log.Printf("Generated coverage report: %s", jsonFile)
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Exit codes of the diff command.
+const (
+	diffExitUnchanged = 0
+	diffExitChanged   = 1
+	diffExitError     = 2
+)
+
+// DiffReport lists the differences between two catalogs.
+type DiffReport struct {
+	Old      string      `json:"old"`
+	New      string      `json:"new"`
+	Changed  bool        `json:"changed"`
+	Services DiffSummary `json:"services"`
+	APIs     DiffSummary `json:"apis"`
+	Changes  []Change    `json:"changes"`
+}
+
+// DiffSummary counts the entries of one type that were added, removed or modified.
+type DiffSummary struct {
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+}
+
+// diffCatalogs compares the catalogs named by oldRef and newRef and writes the report to w
+// in the given format. Each reference is either a folder holding services.json and
+// directory.json, or a git revision whose committed copies are compared.
+// It returns whether the catalogs differ.
+func diffCatalogs(oldRef, newRef, format string, w io.Writer) (bool, error) {
+	var write func(io.Writer, DiffReport) error
+	switch format {
+	case "text":
+		write = writeDiffText
+	case "markdown":
+		write = writeDiffMarkdown
+	case "json":
+		write = writeDiffJSON
+	default:
+		return false, fmt.Errorf("unknown diff format %q, expected text, markdown or json", format)
+	}
+
+	before, err := loadCatalogSnapshot(oldRef)
+	if err != nil {
+		return false, err
+	}
+	after, err := loadCatalogSnapshot(newRef)
+	if err != nil {
+		return false, err
+	}
+
+	report := buildDiffReport(oldRef, newRef, diffSnapshots(before, after))
+	if err := write(w, report); err != nil {
+		return false, fmt.Errorf("failed to write diff: %v", err)
+	}
+	return report.Changed, nil
+}
+
+// loadCatalogSnapshot reads services.json and directory.json from a folder or a git revision.
+func loadCatalogSnapshot(ref string) (*Snapshot, error) {
+	read := func(name string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(ref, name))
+	}
+	if info, err := os.Stat(ref); err != nil || !info.IsDir() {
+		read = func(name string) ([]byte, error) {
+			return gitShow(ref, name)
+		}
+	}
+
+	servicesData, err := read("services.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read services.json from %s: %v", ref, err)
+	}
+	catalog, err := decodeServiceCatalog(servicesData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read services.json from %s: %v", ref, err)
+	}
+
+	directoryData, err := read("directory.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory.json from %s: %v", ref, err)
+	}
+	var directory DirectoryList
+	if err := json.Unmarshal(directoryData, &directory); err != nil {
+		return nil, fmt.Errorf("failed to parse directory.json from %s: %v", ref, err)
+	}
+
+	return newSnapshot("", catalog.Services, directory.Items), nil
+}
+
+// gitShow returns the content of a file at a git revision.
+func gitShow(revision, name string) ([]byte, error) {
+	// git would parse a revision starting with a dash as an option.
+	if strings.HasPrefix(revision, "-") {
+		return nil, fmt.Errorf("invalid git revision %q", revision)
+	}
+	output, err := exec.Command("git", "show", revision+":"+name).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("git show %s:%s: %s", revision, name, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("git show %s:%s: %v", revision, name, err)
+	}
+	return output, nil
+}
+
+// buildDiffReport summarizes the changes between two catalogs.
+func buildDiffReport(oldRef, newRef string, changes []Change) DiffReport {
+	report := DiffReport{
+		Old:     oldRef,
+		New:     newRef,
+		Changed: len(changes) > 0,
+		Changes: changes,
+	}
+	if report.Changes == nil {
+		report.Changes = []Change{}
+	}
+
+	// An entry with several modified fields is counted once.
+	modified := make(map[string]bool)
+	for _, change := range changes {
+		summary := &report.Services
+		if change.Type == changeTypeAPI {
+			summary = &report.APIs
+		}
+		switch change.Kind {
+		case ChangeAdded:
+			summary.Added++
+		case ChangeRemoved:
+			summary.Removed++
+		default:
+			key := change.Type + ":" + change.ID
+			if !modified[key] {
+				modified[key] = true
+				summary.Modified++
+			}
+		}
+	}
+	return report
+}
+
+// describeDiff returns a one line summary of a diff report.
+func describeDiff(report DiffReport) string {
+	if !report.Changed {
+		return "No catalog changes"
+	}
+
+	var parts []string
+	add := func(count int, singular, plural, action string) {
+		switch {
+		case count == 1:
+			parts = append(parts, fmt.Sprintf("1 %s %s", singular, action))
+		case count > 1:
+			parts = append(parts, fmt.Sprintf("%d %s %s", count, plural, action))
+		}
+	}
+	add(report.Services.Added, "service", "services", "added")
+	add(report.Services.Removed, "service", "services", "removed")
+	add(report.Services.Modified, "service", "services", "modified")
+	add(report.APIs.Added, "API", "APIs", "added")
+	add(report.APIs.Removed, "API", "APIs", "removed")
+	add(report.APIs.Modified, "API", "APIs", "modified")
+	return "Catalog update: " + strings.Join(parts, ", ")
+}
+
+// describeChange describes a single change without the ID of the entry.
+func describeChange(change Change) string {
+	switch change.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("added (%s)", change.Title)
+	case ChangeRemoved:
+		return fmt.Sprintf("removed (%s)", change.Title)
+	case ChangeRetitled:
+		return fmt.Sprintf("title changed from %q to %q", change.Old, change.New)
+	case ChangeSummary:
+		return "documentation summary changed"
+	case ChangePreferred:
+		if change.New == preferredLabel(true) {
+			return "became the preferred version"
+		}
+		return "is no longer the preferred version"
+	}
+	return string(change.Kind)
+}
+
+// diffSections groups the changes for the text and Markdown output.
+var diffSections = []struct {
+	Title string
+	Type  string
+	Kinds []ChangeKind
+}{
+	{"Services added", changeTypeService, []ChangeKind{ChangeAdded}},
+	{"Services removed", changeTypeService, []ChangeKind{ChangeRemoved}},
+	{"Services modified", changeTypeService, []ChangeKind{ChangeRetitled, ChangeSummary}},
+	{"APIs added", changeTypeAPI, []ChangeKind{ChangeAdded}},
+	{"APIs removed", changeTypeAPI, []ChangeKind{ChangeRemoved}},
+	{"APIs modified", changeTypeAPI, []ChangeKind{ChangeRetitled, ChangeSummary, ChangePreferred}},
+}
+
+// sectionChanges returns the changes of a type with one of the given kinds.
+func sectionChanges(changes []Change, changeType string, kinds []ChangeKind) []Change {
+	var result []Change
+	for _, change := range changes {
+		if change.Type != changeType {
+			continue
+		}
+		for _, kind := range kinds {
+			if change.Kind == kind {
+				result = append(result, change)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// writeDiffText writes a diff report as plain text.
+func writeDiffText(w io.Writer, report DiffReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", describeDiff(report))
+	for _, section := range diffSections {
+		changes := sectionChanges(report.Changes, section.Type, section.Kinds)
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s:\n", section.Title)
+		for _, change := range changes {
+			fmt.Fprintf(&b, "  %s: %s\n", change.ID, describeChange(change))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeDiffMarkdown writes a diff report as Markdown. The first line is a plain summary
+// so the output can be used as a commit message.
+func writeDiffMarkdown(w io.Writer, report DiffReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", describeDiff(report))
+	for _, section := range diffSections {
+		changes := sectionChanges(report.Changes, section.Type, section.Kinds)
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", section.Title)
+		for _, change := range changes {
+			fmt.Fprintf(&b, "- `%s`: %s\n", change.ID, describeChange(change))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeDiffJSON writes a diff report as indented JSON.
+func writeDiffJSON(w io.Writer, report DiffReport) error {
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(jsonData, '\n'))
+	return err
+}
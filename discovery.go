@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// discoveryDir is the data directory holding one discovery document per API.
+const discoveryDir = "discovery"
+
+// DiscoveryDocument represents the parts of an API discovery document used by the generator.
+type DiscoveryDocument struct {
+	ID          string                        `json:"id"`
+	Name        string                        `json:"name"`
+	Version     string                        `json:"version"`
+	Title       string                        `json:"title"`
+	Description string                        `json:"description"`
+	Revision    string                        `json:"revision"`
+	RootURL     string                        `json:"rootUrl"`
+	MTLSRootURL string                        `json:"mtlsRootUrl"`
+	ServicePath string                        `json:"servicePath"`
+	BasePath    string                        `json:"basePath"`
+	BatchPath   string                        `json:"batchPath"`
+	Auth        DiscoveryAuth                 `json:"auth"`
+	Parameters  map[string]DiscoveryParameter `json:"parameters"`
+	Resources   map[string]DiscoveryResource  `json:"resources"`
+	Methods     map[string]DiscoveryMethod    `json:"methods"`
+	Schemas     map[string]*DiscoverySchema   `json:"schemas"`
+}
+
+// DiscoveryAuth represents the authentication section of a discovery document.
+type DiscoveryAuth struct {
+	OAuth2 struct {
+		Scopes map[string]DiscoveryScope `json:"scopes"`
+	} `json:"oauth2"`
+}
+
+// DiscoveryScope represents an OAuth 2.0 scope accepted by an API.
+type DiscoveryScope struct {
+	Description string `json:"description"`
+}
+
+// DiscoveryResource represents a collection of methods and nested resources.
+type DiscoveryResource struct {
+	Methods    map[string]DiscoveryMethod   `json:"methods"`
+	Resources  map[string]DiscoveryResource `json:"resources"`
+	Deprecated bool                         `json:"deprecated"`
+}
+
+// DiscoveryMethod represents a single REST method of an API.
+type DiscoveryMethod struct {
+	ID                    string                        `json:"id"`
+	Path                  string                        `json:"path"`
+	FlatPath              string                        `json:"flatPath"`
+	HTTPMethod            string                        `json:"httpMethod"`
+	Description           string                        `json:"description"`
+	Parameters            map[string]DiscoveryParameter `json:"parameters"`
+	ParameterOrder        []string                      `json:"parameterOrder"`
+	Request               *DiscoverySchemaRef           `json:"request"`
+	Response              *DiscoverySchemaRef           `json:"response"`
+	Scopes                []string                      `json:"scopes"`
+	SupportsMediaDownload bool                          `json:"supportsMediaDownload"`
+	SupportsMediaUpload   bool                          `json:"supportsMediaUpload"`
+	MediaUpload           *DiscoveryMediaUpload         `json:"mediaUpload"`
+	Deprecated            bool                          `json:"deprecated"`
+}
+
+// DiscoverySchemaRef represents a reference to a schema by its ID.
+type DiscoverySchemaRef struct {
+	Ref string `json:"$ref"`
+}
+
+// DiscoveryMediaUpload represents the media upload settings of a method.
+type DiscoveryMediaUpload struct {
+	Accept    []string `json:"accept"`
+	MaxSize   string   `json:"maxSize"`
+	Protocols map[string]struct {
+		Multipart bool   `json:"multipart"`
+		Path      string `json:"path"`
+	} `json:"protocols"`
+}
+
+// DiscoveryParameter represents a path, query or global parameter.
+type DiscoveryParameter struct {
+	Type             string   `json:"type"`
+	Format           string   `json:"format"`
+	Description      string   `json:"description"`
+	Location         string   `json:"location"`
+	Pattern          string   `json:"pattern"`
+	Default          string   `json:"default"`
+	Required         bool     `json:"required"`
+	Repeated         bool     `json:"repeated"`
+	Deprecated       bool     `json:"deprecated"`
+	Enum             []string `json:"enum"`
+	EnumDescriptions []string `json:"enumDescriptions"`
+}
+
+// DiscoverySchema represents a schema, or a property or item type nested in one.
+type DiscoverySchema struct {
+	ID                   string                      `json:"id"`
+	Type                 string                      `json:"type"`
+	Format               string                      `json:"format"`
+	Description          string                      `json:"description"`
+	Ref                  string                      `json:"$ref"`
+	Properties           map[string]*DiscoverySchema `json:"properties"`
+	Items                *DiscoverySchema            `json:"items"`
+	AdditionalProperties *DiscoverySchema            `json:"additionalProperties"`
+	Enum                 []string                    `json:"enum"`
+	EnumDescriptions     []string                    `json:"enumDescriptions"`
+	EnumDeprecated       []bool                      `json:"enumDeprecated"`
+	ReadOnly             bool                        `json:"readOnly"`
+	Deprecated           bool                        `json:"deprecated"`
+}
+
+// ResourceCount returns the number of resources in the document, including nested ones.
+func (d *DiscoveryDocument) ResourceCount() int {
+	var count func(resources map[string]DiscoveryResource) int
+	count = func(resources map[string]DiscoveryResource) int {
+		total := len(resources)
+		for _, resource := range resources {
+			total += count(resource.Resources)
+		}
+		return total
+	}
+	return count(d.Resources)
+}
+
+// MethodCount returns the number of methods in the document, including those of nested resources.
+func (d *DiscoveryDocument) MethodCount() int {
+	var count func(resources map[string]DiscoveryResource) int
+	count = func(resources map[string]DiscoveryResource) int {
+		total := 0
+		for _, resource := range resources {
+			total += len(resource.Methods) + count(resource.Resources)
+		}
+		return total
+	}
+	return len(d.Methods) + count(d.Resources)
+}
+
+// discoveryPath returns the file a discovery document is stored in, keyed by API ID.
+func discoveryPath(apiID string) string {
+	return discoveryPathIn(discoveryDir, apiID)
+}
+
+// discoveryPathIn returns the file in dir a discovery document is written to.
+func discoveryPathIn(dir, apiID string) string {
+	return filepath.Join(dir, strings.ReplaceAll(apiID, ":", "_")+".json")
+}
+
+// crawlDiscoveryDocuments fetches the discovery document of every API into dir using a
+// bounded pool of workers, removing the documents of APIs no longer in the directory.
+// Documents recorded in the checkpoint were saved earlier in a resumed crawl and are not
+// fetched again. It returns the number of documents that were saved.
+func crawlDiscoveryDocuments(ctx context.Context, client *http.Client, apis []APIEntry, dir string, workers int, checkpoint *CrawlCheckpoint) (int, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, fmt.Errorf("failed to create discovery directory: %v", err)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan APIEntry)
+	var mu sync.Mutex
+	var failed []string
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for api := range jobs {
+				if checkpoint.documentFetched(api.ID) {
+					continue
+				}
+				if err := crawlDiscoveryDocument(ctx, client, api, discoveryPathIn(dir, api.ID)); err != nil {
+					log.Printf("Failed to fetch discovery document for %s: %v", api.ID, err)
+					mu.Lock()
+					failed = append(failed, api.ID)
+					mu.Unlock()
+					continue
+				}
+				if err := checkpoint.markDocumentFetched(api.ID); err != nil {
+					log.Printf("Warning: failed to checkpoint discovery document for %s: %v", api.ID, err)
+				}
+			}
+		})
+	}
+	for _, api := range apis {
+		jobs <- api
+	}
+	close(jobs)
+	wg.Wait()
+
+	saved := len(apis) - len(failed)
+	if err := pruneDiscoveryDocuments(dir, apis); err != nil {
+		return saved, err
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return saved, fmt.Errorf("failed to fetch %d of %d discovery documents: %s",
+			len(failed), len(apis), strings.Join(failed, ", "))
+	}
+
+	fmt.Printf("Discovery documents saved to %s\n", dir)
+	return saved, nil
+}
+
+// crawlDiscoveryDocument fetches the discovery document for a single API and writes it to path.
+func crawlDiscoveryDocument(ctx context.Context, client *http.Client, api APIEntry, path string) error {
+	body, err := fetchURL(ctx, client, api.DiscoveryRestURL)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the document parses before replacing the stored copy.
+	var doc DiscoveryDocument
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("failed to parse discovery document: %v", err)
+	}
+
+	if err := writeFileAtomic(path, body, 0644); err != nil {
+		return fmt.Errorf("failed to write discovery document: %v", err)
+	}
+	return nil
+}
+
+// pruneDiscoveryDocuments removes the documents in dir for APIs that are not in the list.
+func pruneDiscoveryDocuments(dir string, apis []APIEntry) error {
+	keep := make(map[string]bool, len(apis))
+	for _, api := range apis {
+		keep[discoveryPathIn(dir, api.ID)] = true
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list discovery documents: %v", err)
+	}
+	for _, path := range paths {
+		if keep[path] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale discovery document %s: %v", path, err)
+		}
+		log.Printf("Removed stale discovery document %s", path)
+	}
+	return nil
+}
+
+// readDiscoveryDocument reads the stored discovery document for an API.
+func readDiscoveryDocument(apiID string) (*DiscoveryDocument, error) {
+	data, err := os.ReadFile(discoveryPath(apiID))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc DiscoveryDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery document: %v", err)
+	}
+	return &doc, nil
+}
+
+// APIResource is a resource of a discovery document flattened for page generation.
+// Methods defined directly on the document are grouped under a resource with an empty path.
+type APIResource struct {
+	Path       string
+	Deprecated bool
+	Methods    []APIMethod
+}
+
+// APIMethod is a discovery method along with the resource it belongs to.
+type APIMethod struct {
+	DiscoveryMethod
+	Name     string
+	Resource string
+}
+
+// NamedParameter is a discovery parameter along with its name.
+type NamedParameter struct {
+	DiscoveryParameter
+	Name string
+}
+
+// FlatResources returns every resource in the document sorted by path,
+// each with its methods sorted by name.
+func (d *DiscoveryDocument) FlatResources() []APIResource {
+	var resources []APIResource
+	if len(d.Methods) > 0 {
+		resources = append(resources, APIResource{Methods: sortedMethods("", d.Methods)})
+	}
+
+	var walk func(prefix string, children map[string]DiscoveryResource)
+	walk = func(prefix string, children map[string]DiscoveryResource) {
+		for name, child := range children {
+			path := prefix + name
+			resources = append(resources, APIResource{
+				Path:       path,
+				Deprecated: child.Deprecated,
+				Methods:    sortedMethods(path, child.Methods),
+			})
+			walk(path+".", child.Resources)
+		}
+	}
+	walk("", d.Resources)
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Path < resources[j].Path
+	})
+	return resources
+}
+
+// sortedMethods converts a method map into a slice sorted by method name.
+func sortedMethods(resource string, methods map[string]DiscoveryMethod) []APIMethod {
+	result := make([]APIMethod, 0, len(methods))
+	for name, method := range methods {
+		result = append(result, APIMethod{
+			DiscoveryMethod: method,
+			Name:            name,
+			Resource:        resource,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// FileName returns a file-safe name for the method page, based on the method ID.
+func (m APIMethod) FileName() string {
+	if m.ID != "" {
+		return m.ID
+	}
+	if m.Resource == "" {
+		return m.Name
+	}
+	return m.Resource + "." + m.Name
+}
+
+// PathParameters returns the path parameters in the order they appear in the path.
+func (m APIMethod) PathParameters() []NamedParameter {
+	var result []NamedParameter
+	seen := make(map[string]bool)
+	for _, name := range m.ParameterOrder {
+		if param, ok := m.Parameters[name]; ok && param.Location == "path" {
+			result = append(result, NamedParameter{DiscoveryParameter: param, Name: name})
+			seen[name] = true
+		}
+	}
+	for _, param := range m.parametersAt("path") {
+		if !seen[param.Name] {
+			result = append(result, param)
+		}
+	}
+	return result
+}
+
+// QueryParameters returns the query parameters sorted by name.
+func (m APIMethod) QueryParameters() []NamedParameter {
+	return m.parametersAt("query")
+}
+
+// parametersAt returns the parameters with the given location sorted by name.
+func (m APIMethod) parametersAt(location string) []NamedParameter {
+	var result []NamedParameter
+	for name, param := range m.Parameters {
+		if param.Location == location {
+			result = append(result, NamedParameter{DiscoveryParameter: param, Name: name})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// MethodURL returns the full REST URL template of a method, preferring the flat path.
+func (d *DiscoveryDocument) MethodURL(m APIMethod) string {
+	path := m.FlatPath
+	if path == "" {
+		path = m.Path
+	}
+	return d.RootURL + d.ServicePath + path
+}
+
+// schemaType describes the type of a schema or property for display,
+// such as "string (int64)", "array of Topic" or "map of string to string".
+func schemaType(s *DiscoverySchema) string {
+	if s == nil {
+		return ""
+	}
+	switch {
+	case s.Ref != "":
+		return s.Ref
+	case s.Type == "array":
+		return "array of " + schemaType(s.Items)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "map of string to " + schemaType(s.AdditionalProperties)
+	case s.Format != "":
+		return fmt.Sprintf("%s (%s)", s.Type, s.Format)
+	}
+	return s.Type
+}
+
+// EnumValue is an allowed value of an enum along with its description.
+type EnumValue struct {
+	Value       string
+	Description string
+	Deprecated  bool
+}
+
+// EnumValues pairs the allowed values of a parameter with their descriptions.
+func (p DiscoveryParameter) EnumValues() []EnumValue {
+	return enumValues(p.Enum, p.EnumDescriptions, nil)
+}
+
+// enumValues pairs enum values with their descriptions and deprecation markers,
+// tolerating description lists that are shorter than the values.
+func enumValues(values, descriptions []string, deprecated []bool) []EnumValue {
+	result := make([]EnumValue, 0, len(values))
+	for i, value := range values {
+		ev := EnumValue{Value: value}
+		if i < len(descriptions) {
+			ev.Description = descriptions[i]
+		}
+		if i < len(deprecated) {
+			ev.Deprecated = deprecated[i]
+		}
+		result = append(result, ev)
+	}
+	return result
+}
+
+// EnumValues pairs the allowed values of a schema with their descriptions.
+// For arrays the values of the item type are returned.
+func (s *DiscoverySchema) EnumValues() []EnumValue {
+	if len(s.Enum) == 0 && s.Items != nil {
+		return s.Items.EnumValues()
+	}
+	return enumValues(s.Enum, s.EnumDescriptions, s.EnumDeprecated)
+}
+
+// schemaRefs returns the sorted, unique names of the schemas a schema refers to,
+// looking through its properties, items and map values.
+func schemaRefs(s *DiscoverySchema) []string {
+	seen := make(map[string]bool)
+	var walk func(s *DiscoverySchema)
+	walk = func(s *DiscoverySchema) {
+		if s == nil {
+			return
+		}
+		if s.Ref != "" {
+			seen[s.Ref] = true
+		}
+		for _, property := range s.Properties {
+			walk(property)
+		}
+		walk(s.Items)
+		walk(s.AdditionalProperties)
+	}
+	walk(s)
+
+	refs := make([]string, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// schemaLink describes the type of a schema like schemaType, linking every referenced
+// schema to its page in the sibling schema folder.
+func schemaLink(s *DiscoverySchema) template.HTML {
+	if s == nil {
+		return ""
+	}
+	switch {
+	case s.Ref != "":
+		ref := template.HTMLEscapeString(s.Ref)
+		return template.HTML(fmt.Sprintf(`<a href="../schema/%s.html">%s</a>`, ref, ref))
+	case s.Type == "array":
+		return "array of " + schemaLink(s.Items)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		return "map of string to " + schemaLink(s.AdditionalProperties)
+	}
+	return template.HTML(template.HTMLEscapeString(schemaType(s)))
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	serviceusagepb "cloud.google.com/go/serviceusage/apiv1/serviceusagepb"
+	"google.golang.org/genproto/googleapis/api"
+	"google.golang.org/genproto/googleapis/api/label"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/genproto/googleapis/api/serviceconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/apipb"
+)
+
+// Page sizes of the fake ListServices, matching the Service Usage API.
+const (
+	fakeDefaultPageSize = 50
+	fakeMaxPageSize     = 200
+)
+
+// fakeDiscoveryPath is the path the fake server serves the API directory on.
+const fakeDiscoveryPath = "/discovery/v1/apis"
+
+// fakeServiceUsage serves a saved services.json through the Service Usage gRPC API.
+// Each service is reported in the state it was crawled in, or as DISABLED when none was recorded.
+type fakeServiceUsage struct {
+	serviceusagepb.UnimplementedServiceUsageServer
+
+	services []Service
+	byName   map[string]int
+}
+
+// newFakeServiceUsage indexes the services of a catalog by name.
+func newFakeServiceUsage(catalog *ServiceCatalog) *fakeServiceUsage {
+	fake := &fakeServiceUsage{
+		services: catalog.Services,
+		byName:   make(map[string]int, len(catalog.Services)),
+	}
+	for i, svc := range catalog.Services {
+		fake.byName[svc.Name] = i
+	}
+	return fake
+}
+
+// serviceState returns the state a service is reported in.
+func (f *fakeServiceUsage) serviceState(svc Service) serviceusagepb.State {
+	if svc.State == serviceusagepb.State_ENABLED.String() {
+		return serviceusagepb.State_ENABLED
+	}
+	return serviceusagepb.State_DISABLED
+}
+
+// ListServices lists the services in pages, optionally filtered by "state:ENABLED" or
+// "state:DISABLED". The page token is the offset of the first service of the page.
+func (f *fakeServiceUsage) ListServices(ctx context.Context, req *serviceusagepb.ListServicesRequest) (*serviceusagepb.ListServicesResponse, error) {
+	if !strings.HasPrefix(req.GetParent(), "projects/") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid parent %q", req.GetParent())
+	}
+
+	var state serviceusagepb.State
+	switch filter := strings.TrimSpace(req.GetFilter()); filter {
+	case "":
+	case "state:ENABLED":
+		state = serviceusagepb.State_ENABLED
+	case "state:DISABLED":
+		state = serviceusagepb.State_DISABLED
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported filter %q", filter)
+	}
+
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = fakeDefaultPageSize
+	}
+	if pageSize > fakeMaxPageSize {
+		pageSize = fakeMaxPageSize
+	}
+
+	offset := 0
+	if token := req.GetPageToken(); token != "" {
+		var err error
+		offset, err = strconv.Atoi(token)
+		if err != nil || offset < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token %q", token)
+		}
+	}
+
+	var matched []Service
+	for _, svc := range f.services {
+		if state == serviceusagepb.State_STATE_UNSPECIFIED || f.serviceState(svc) == state {
+			matched = append(matched, svc)
+		}
+	}
+
+	resp := &serviceusagepb.ListServicesResponse{}
+	for i := offset; i < len(matched) && i < offset+pageSize; i++ {
+		resp.Services = append(resp.Services, f.serviceMessage(req.GetParent(), matched[i]))
+	}
+	if offset+pageSize < len(matched) {
+		resp.NextPageToken = strconv.Itoa(offset + pageSize)
+	}
+	return resp, nil
+}
+
+// GetService returns a single service by its "projects/*/services/*" resource name.
+func (f *fakeServiceUsage) GetService(ctx context.Context, req *serviceusagepb.GetServiceRequest) (*serviceusagepb.Service, error) {
+	parent, name, ok := strings.Cut(req.GetName(), "/services/")
+	if !ok || !strings.HasPrefix(parent, "projects/") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid service name %q", req.GetName())
+	}
+	i, ok := f.byName[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "service %q not found", name)
+	}
+	return f.serviceMessage(parent, f.services[i]), nil
+}
+
+// BatchGetServices returns several services of a project by resource name.
+func (f *fakeServiceUsage) BatchGetServices(ctx context.Context, req *serviceusagepb.BatchGetServicesRequest) (*serviceusagepb.BatchGetServicesResponse, error) {
+	resp := &serviceusagepb.BatchGetServicesResponse{}
+	for _, name := range req.GetNames() {
+		svc, err := f.GetService(ctx, &serviceusagepb.GetServiceRequest{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		resp.Services = append(resp.Services, svc)
+	}
+	return resp, nil
+}
+
+// serviceMessage builds the Service Usage response for a service of a project.
+func (f *fakeServiceUsage) serviceMessage(parent string, svc Service) *serviceusagepb.Service {
+	return &serviceusagepb.Service{
+		Name:   parent + "/services/" + svc.Name,
+		Parent: parent,
+		Config: configFromService(svc),
+		State:  f.serviceState(svc),
+	}
+}
+
+// configFromService converts a saved service back into a service configuration,
+// the reverse of serviceFromConfig.
+func configFromService(svc Service) *serviceusagepb.ServiceConfig {
+	cfg := &serviceusagepb.ServiceConfig{
+		Name:  svc.Name,
+		Title: svc.Title,
+	}
+	if svc.Documentation != "" {
+		cfg.Documentation = &serviceconfig.Documentation{Summary: svc.Documentation}
+	}
+
+	for _, a := range svc.APIs {
+		converted := &apipb.Api{Name: a.Name, Version: a.Version}
+		for _, method := range a.Methods {
+			converted.Methods = append(converted.Methods, &apipb.Method{Name: method})
+		}
+		for _, mixin := range a.Mixins {
+			converted.Mixins = append(converted.Mixins, &apipb.Mixin{Name: mixin})
+		}
+		cfg.Apis = append(cfg.Apis, converted)
+	}
+
+	for _, endpoint := range svc.Endpoints {
+		cfg.Endpoints = append(cfg.Endpoints, &serviceconfig.Endpoint{
+			Name:      endpoint.Name,
+			Aliases:   endpoint.Aliases,
+			Target:    endpoint.Target,
+			AllowCors: endpoint.AllowCORS,
+		})
+	}
+
+	if auth := svc.Authentication; auth != nil {
+		cfg.Authentication = &serviceconfig.Authentication{}
+		for _, rule := range auth.Rules {
+			converted := &serviceconfig.AuthenticationRule{
+				Selector:               rule.Selector,
+				AllowWithoutCredential: rule.AllowWithoutCredential,
+			}
+			if len(rule.OAuthScopes) > 0 {
+				converted.Oauth = &serviceconfig.OAuthRequirements{CanonicalScopes: strings.Join(rule.OAuthScopes, ",")}
+			}
+			for _, provider := range rule.Providers {
+				converted.Requirements = append(converted.Requirements, &serviceconfig.AuthRequirement{ProviderId: provider})
+			}
+			cfg.Authentication.Rules = append(cfg.Authentication.Rules, converted)
+		}
+		for _, provider := range auth.Providers {
+			cfg.Authentication.Providers = append(cfg.Authentication.Providers, &serviceconfig.AuthProvider{
+				Id:        provider.ID,
+				Issuer:    provider.Issuer,
+				JwksUri:   provider.JwksURI,
+				Audiences: provider.Audiences,
+			})
+		}
+	}
+
+	if usage := svc.Usage; usage != nil {
+		cfg.Usage = &serviceconfig.Usage{
+			Requirements:                usage.Requirements,
+			ProducerNotificationChannel: usage.ProducerNotificationChannel,
+		}
+		for _, rule := range usage.Rules {
+			cfg.Usage.Rules = append(cfg.Usage.Rules, &serviceconfig.UsageRule{
+				Selector:               rule.Selector,
+				AllowUnregisteredCalls: rule.AllowUnregisteredCalls,
+				SkipServiceControl:     rule.SkipServiceControl,
+			})
+		}
+	}
+
+	for _, resource := range svc.MonitoredResources {
+		converted := &monitoredres.MonitoredResourceDescriptor{
+			Type:        resource.Type,
+			DisplayName: resource.DisplayName,
+			Description: resource.Description,
+			LaunchStage: api.LaunchStage(api.LaunchStage_value[resource.LaunchStage]),
+		}
+		for _, l := range resource.Labels {
+			converted.Labels = append(converted.Labels, &label.LabelDescriptor{
+				Key:         l.Key,
+				ValueType:   label.LabelDescriptor_ValueType(label.LabelDescriptor_ValueType_value[l.ValueType]),
+				Description: l.Description,
+			})
+		}
+		cfg.MonitoredResources = append(cfg.MonitoredResources, converted)
+	}
+
+	if monitoring := svc.Monitoring; monitoring != nil {
+		cfg.Monitoring = &serviceconfig.Monitoring{
+			ProducerDestinations: monitoringDestinationsConfig(monitoring.ProducerDestinations),
+			ConsumerDestinations: monitoringDestinationsConfig(monitoring.ConsumerDestinations),
+		}
+	}
+	return cfg
+}
+
+// monitoringDestinationsConfig converts a list of saved monitoring destinations.
+func monitoringDestinationsConfig(destinations []MonitoringDestination) []*serviceconfig.Monitoring_MonitoringDestination {
+	var result []*serviceconfig.Monitoring_MonitoringDestination
+	for _, destination := range destinations {
+		result = append(result, &serviceconfig.Monitoring_MonitoringDestination{
+			MonitoredResource: destination.MonitoredResource,
+			Metrics:           destination.Metrics,
+		})
+	}
+	return result
+}
+
+// fakeDiscovery serves a saved directory.json and the stored discovery documents in the
+// shape of the Discovery API.
+type fakeDiscovery struct {
+	directory *DirectoryList
+}
+
+// ServeHTTP serves the directory on fakeDiscoveryPath, supporting the name and preferred
+// parameters, and each discovery document on fakeDiscoveryPath/{name}/{version}/rest.
+// The discovery URLs in the directory are rewritten to point at this server.
+func (f *fakeDiscovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == fakeDiscoveryPath {
+		f.serveDirectory(w, r)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, fakeDiscoveryPath+"/")
+	parts := strings.Split(rest, "/")
+	if !ok || len(parts) != 3 || parts[2] != "rest" {
+		http.NotFound(w, r)
+		return
+	}
+	for _, a := range f.directory.Items {
+		if a.Name == parts[0] && a.Version == parts[1] {
+			http.ServeFile(w, r, discoveryPath(a.ID))
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// serveDirectory writes the directory, filtered by the query parameters of the request.
+func (f *fakeDiscovery) serveDirectory(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	preferred := r.URL.Query().Get("preferred") == "true"
+	base := "http://" + r.Host + fakeDiscoveryPath
+
+	directory := *f.directory
+	directory.Items = []APIEntry{}
+	for _, a := range f.directory.Items {
+		if (name != "" && a.Name != name) || (preferred && !a.Preferred) {
+			continue
+		}
+		a.DiscoveryRestURL = fmt.Sprintf("%s/%s/%s/rest", base, a.Name, a.Version)
+		directory.Items = append(directory.Items, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(directory); err != nil {
+		log.Printf("Failed to write directory response: %v", err)
+	}
+}
+
+// runFakeServer serves services.json over gRPC on grpcAddr and directory.json with the
+// discovery documents over HTTP on httpAddr until either server stops or ctx is done.
+func runFakeServer(ctx context.Context, grpcAddr, httpAddr string) error {
+	catalog, err := readServiceCatalog("services.json")
+	if err != nil {
+		return fmt.Errorf("failed to read services.json: %v", err)
+	}
+	directory, err := readDirectory("directory.json")
+	if err != nil {
+		return fmt.Errorf("failed to read directory.json: %v", err)
+	}
+
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", grpcAddr, err)
+	}
+	httpListener, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		grpcListener.Close()
+		return fmt.Errorf("failed to listen on %s: %v", httpAddr, err)
+	}
+
+	grpcServer := grpc.NewServer()
+	serviceusagepb.RegisterServiceUsageServer(grpcServer, newFakeServiceUsage(catalog))
+	httpServer := &http.Server{Handler: &fakeDiscovery{directory: directory}}
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- grpcServer.Serve(grpcListener)
+	}()
+	go func() {
+		errs <- httpServer.Serve(httpListener)
+	}()
+
+	log.Printf("Serving %d services over gRPC on %s", len(catalog.Services), grpcListener.Addr())
+	log.Printf("Serving %d APIs over HTTP on http://%s%s", len(directory.Items), httpListener.Addr(), fakeDiscoveryPath)
+	log.Printf("Crawl it with: -crawl -serviceusage-endpoint %s -serviceusage-insecure -discovery-url http://%s%s\n",
+		grpcListener.Addr(), httpListener.Addr(), fakeDiscoveryPath)
+
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		log.Printf("Shutting down the fake server")
+	}
+	grpcServer.Stop()
+	httpServer.Close()
+	return err
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// feedEntryLimit is the largest number of entries written to a feed.
+const feedEntryLimit = 100
+
+// AtomFeed represents an Atom feed document.
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  AtomAuthor  `xml:"author"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+// AtomAuthor represents the author of an Atom feed.
+type AtomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// AtomLink represents a link in an Atom feed or entry.
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// AtomEntry represents a single change in an Atom feed.
+type AtomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    AtomLink `xml:"link"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+// feedFileName returns the file name of the feed for a domain.
+func feedFileName(domain string) string {
+	return fmt.Sprintf("domain-%s.xml", urlSafe(domain))
+}
+
+// generateFeeds writes an Atom feed of the changelog for the whole catalog to feed.xml,
+// and one feed per domain into the "feeds" folder.
+func generateFeeds(htmlDir string, changelog []ChangeDay, domains []string) error {
+	website := os.Getenv("WEBSITE")
+	if website == "" {
+		return fmt.Errorf("environment variable 'WEBSITE' is not set")
+	}
+	website = strings.TrimRight(website, "/")
+
+	feedFile := filepath.Join(htmlDir, "feed.xml")
+	feed := newChangeFeed(website, "feed.xml", "gcp-service-catalog changes", changelog, "")
+	if err := writeFeed(feedFile, feed); err != nil {
+		return err
+	}
+	log.Printf("Generated feed: %s", feedFile)
+
+	feedDir := filepath.Join(htmlDir, "feeds")
+	if err := os.MkdirAll(feedDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create feeds directory: %v", err)
+	}
+	for _, domain := range domains {
+		name := feedFileName(domain)
+		feed := newChangeFeed(website, "feeds/"+name, fmt.Sprintf("gcp-service-catalog changes in %s", domain), changelog, domain)
+		if err := writeFeed(filepath.Join(feedDir, name), feed); err != nil {
+			return err
+		}
+	}
+	log.Printf("Generated %d domain feeds in %s", len(domains), feedDir)
+	return nil
+}
+
+// newChangeFeed builds a feed of the most recent changes, limited to a domain unless it is empty.
+func newChangeFeed(website, path, title string, changelog []ChangeDay, domain string) AtomFeed {
+	feed := AtomFeed{
+		Xmlns:  "http://www.w3.org/2005/Atom",
+		ID:     website + "/" + path,
+		Title:  title,
+		Author: AtomAuthor{Name: "gcp-service-catalog"},
+		Links: []AtomLink{
+			{Href: website + "/" + path, Rel: "self", Type: "application/atom+xml"},
+			{Href: website + "/changes.html", Rel: "alternate", Type: "text/html"},
+		},
+	}
+
+days:
+	for _, day := range changelog {
+		for _, change := range day.Changes {
+			if domain != "" && change.Domain != domain {
+				continue
+			}
+			if len(feed.Entries) == feedEntryLimit {
+				break days
+			}
+			feed.Entries = append(feed.Entries, newChangeEntry(website, day.Date, change))
+		}
+	}
+
+	// The feed was last updated by its newest entry.
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	} else {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	return feed
+}
+
+// newChangeEntry builds the feed entry describing a change found on date.
+func newChangeEntry(website, date string, change Change) AtomEntry {
+	entryType := "Service"
+	if change.Type == changeTypeAPI {
+		entryType = "API"
+	}
+
+	entry := AtomEntry{
+		ID:      fmt.Sprintf("%s/changes.html#%s/%s/%s/%s", website, date, change.Type, change.ID, change.Kind),
+		Title:   fmt.Sprintf("%s %s: %s (%s)", entryType, change.Kind, change.Title, change.ID),
+		Updated: date + "T00:00:00Z",
+		Link:    AtomLink{Href: website + "/changes.html", Rel: "alternate"},
+	}
+	if change.Link != "" {
+		entry.Link.Href = website + "/" + change.Link
+	}
+	if change.Old != "" || change.New != "" {
+		entry.Summary = fmt.Sprintf("Changed from %q to %q", change.Old, change.New)
+	}
+	return entry
+}
+
+// writeFeed writes a feed as an XML document.
+func writeFeed(path string, feed AtomFeed) error {
+	output, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal feed %s: %v", path, err)
+	}
+	output = append([]byte(xml.Header), output...)
+	if err := writeFileAtomic(path, output, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
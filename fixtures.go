@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Folders and files inside a fixtures directory.
+const (
+	fixtureManifestFile  = "manifest.json"
+	serviceUsageFixtures = "serviceusage"
+	httpFixtures         = "http"
+)
+
+// errNoFixture is returned for an HTTP request a replay has no fixture for. It is not
+// retried, as the fixture will not appear.
+var errNoFixture = errors.New("no fixture recorded")
+
+// replayServiceEndpoint is given to the Service Usage client during a replay. It is
+// never dialed, as every call is answered from the fixtures.
+const replayServiceEndpoint = "replay.invalid:443"
+
+// FixtureManifest describes a recorded crawl.
+type FixtureManifest struct {
+	RecordedAt time.Time `json:"recordedAt"`
+	// Project is the project the Service Usage requests were made for. The recorded
+	// requests name it, so a replay has to use the same project.
+	Project string `json:"project"`
+}
+
+// GRPCFixture is a recorded Service Usage call.
+type GRPCFixture struct {
+	Method   string          `json:"method"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    *FixtureError   `json:"error,omitempty"`
+}
+
+// HTTPFixture is a recorded HTTP response.
+type HTTPFixture struct {
+	Method string        `json:"method"`
+	URL    string        `json:"url"`
+	Status int           `json:"status,omitempty"`
+	Header http.Header   `json:"header,omitempty"`
+	Body   string        `json:"body,omitempty"`
+	Error  *FixtureError `json:"error,omitempty"`
+}
+
+// FixtureError is a recorded failure. Code is the gRPC status code, or zero for HTTP.
+type FixtureError struct {
+	Code    codes.Code `json:"code,omitempty"`
+	Message string     `json:"message"`
+}
+
+// setupFixtures records the requests of the crawl into opts.RecordDir, or answers
+// them from opts.ReplayDir, by wrapping the HTTP client and the Service Usage client.
+func setupFixtures(env *crawlEnv) error {
+	opts := env.Options
+	switch {
+	case opts.RecordDir != "":
+		for _, dir := range []string{serviceUsageFixtures, httpFixtures} {
+			if err := os.MkdirAll(filepath.Join(opts.RecordDir, dir), os.ModePerm); err != nil {
+				return fmt.Errorf("failed to create fixtures directory: %v", err)
+			}
+		}
+		manifest := FixtureManifest{RecordedAt: time.Now().UTC(), Project: os.Getenv("GCP_PROJECT_ID")}
+		if err := writeFixture(filepath.Join(opts.RecordDir, fixtureManifestFile), manifest); err != nil {
+			return err
+		}
+		env.Client.Transport = &recordingTransport{dir: opts.RecordDir, next: env.Client.Transport}
+		env.ServiceUsageOptions = append(env.ServiceUsageOptions,
+			option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(recordInterceptor(opts.RecordDir))))
+		log.Printf("Recording fixtures to %s", opts.RecordDir)
+
+	case opts.ReplayDir != "":
+		env.Client.Transport = &replayTransport{dir: opts.ReplayDir}
+		env.ServiceUsageOptions = []option.ClientOption{
+			option.WithEndpoint(replayServiceEndpoint),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+			option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(replayInterceptor(opts.ReplayDir))),
+		}
+		log.Printf("Replaying fixtures from %s", opts.ReplayDir)
+	}
+	return nil
+}
+
+// readFixtureManifest reads the manifest of a fixtures directory.
+func readFixtureManifest(dir string) (*FixtureManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, fixtureManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest FixtureManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", fixtureManifestFile, err)
+	}
+	return &manifest, nil
+}
+
+// fixtureKey returns a short stable name for a request.
+func fixtureKey(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// grpcFixturePath returns the fixture file of a Service Usage call, named after the
+// method and a hash of the request.
+func grpcFixturePath(dir, method string, req proto.Message) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s request: %v", method, err)
+	}
+	name := fmt.Sprintf("%s-%s.json", path.Base(method), fixtureKey([]byte(method), data))
+	return filepath.Join(dir, serviceUsageFixtures, name), nil
+}
+
+// httpFixturePath returns the fixture file of an HTTP request, named after the host
+// and a hash of the method and URL.
+func httpFixturePath(dir string, req *http.Request) string {
+	host := req.URL.Hostname()
+	if host == "" {
+		host = req.URL.Scheme
+	}
+	name := fmt.Sprintf("%s-%s.json", host, fixtureKey([]byte(req.Method), []byte(req.URL.String())))
+	return filepath.Join(dir, httpFixtures, name)
+}
+
+// writeFixture writes a fixture as indented JSON.
+func writeFixture(path string, fixture any) error {
+	jsonData, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal fixture %s: %v", path, err)
+	}
+	if err := writeFileAtomic(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write fixture %s: %v", path, err)
+	}
+	return nil
+}
+
+// recordInterceptor saves every Service Usage call and its response or error.
+// A retried call overwrites the fixture, so the last attempt is kept.
+func recordInterceptor(dir string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		callErr := invoker(ctx, method, req, reply, cc, opts...)
+
+		fixturePath, err := grpcFixturePath(dir, method, req.(proto.Message))
+		if err != nil {
+			log.Printf("Warning: failed to record %s: %v", method, err)
+			return callErr
+		}
+		fixture := GRPCFixture{Method: method}
+		if fixture.Request, err = protojson.Marshal(req.(proto.Message)); err != nil {
+			log.Printf("Warning: failed to record %s: %v", method, err)
+			return callErr
+		}
+		if callErr != nil {
+			s := status.Convert(callErr)
+			fixture.Error = &FixtureError{Code: s.Code(), Message: s.Message()}
+		} else if fixture.Response, err = protojson.Marshal(reply.(proto.Message)); err != nil {
+			log.Printf("Warning: failed to record %s: %v", method, err)
+			return callErr
+		}
+		if err := writeFixture(fixturePath, fixture); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		return callErr
+	}
+}
+
+// replayInterceptor answers every Service Usage call from the recorded fixtures
+// without contacting a server.
+func replayInterceptor(dir string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		fixturePath, err := grpcFixturePath(dir, method, req.(proto.Message))
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(fixturePath)
+		if err != nil {
+			return status.Errorf(codes.NotFound, "no fixture for %s: %v", method, err)
+		}
+		var fixture GRPCFixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return fmt.Errorf("failed to parse fixture %s: %v", fixturePath, err)
+		}
+		if fixture.Error != nil {
+			return status.Error(fixture.Error.Code, fixture.Error.Message)
+		}
+		if err := protojson.Unmarshal(fixture.Response, reply.(proto.Message)); err != nil {
+			return fmt.Errorf("failed to parse fixture %s: %v", fixturePath, err)
+		}
+		return nil
+	}
+}
+
+// recordingTransport saves every HTTP response, or the error of a failed request.
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fixture := HTTPFixture{Method: req.Method, URL: req.URL.String()}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		fixture.Error = &FixtureError{Message: err.Error()}
+	} else {
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		fixture.Status = resp.StatusCode
+		fixture.Header = resp.Header
+		fixture.Body = string(body)
+	}
+
+	if writeErr := writeFixture(httpFixturePath(t.dir, req), fixture); writeErr != nil {
+		log.Printf("Warning: %v", writeErr)
+	}
+	return resp, err
+}
+
+// replayTransport answers every HTTP request from the recorded fixtures.
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	data, err := os.ReadFile(httpFixturePath(t.dir, req))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %s %s", errNoFixture, req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fixture HTTPFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture for %s: %v", req.URL, err)
+	}
+	if fixture.Error != nil {
+		return nil, errors.New(fixture.Error.Message)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fixture.Status, http.StatusText(fixture.Status)),
+		StatusCode:    fixture.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        fixture.Header,
+		Body:          io.NopCloser(strings.NewReader(fixture.Body)),
+		ContentLength: int64(len(fixture.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readTree returns the content of every file under dir by its relative path.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestReplayCrawl(t *testing.T) {
+	fixtures, err := filepath.Abs(filepath.Join("testdata", "replay"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readFixtureManifest(fixtures)
+	if err != nil {
+		t.Fatalf("readFixtureManifest() error = %v", err)
+	}
+	// The data committed in this repository, far larger than the recorded crawl.
+	committedDirectory, err := os.ReadFile("directory.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	committedServices, err := os.ReadFile("services.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// repo holds the files in the repository the replay runs in.
+		repo map[string]string
+	}{
+		{
+			name: "empty repository",
+		},
+		{
+			name: "repository with the committed catalog",
+			repo: map[string]string{
+				"directory.json": string(committedDirectory),
+				"services.json":  string(committedServices),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GCP_PROJECT_ID", manifest.Project)
+			repo := t.TempDir()
+			t.Chdir(repo)
+			for name, content := range tt.repo {
+				if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sources, err := selectSources("directory,discovery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := t.TempDir()
+			opts := crawlOptions{
+				MaxShrink:        10,
+				DiscoveryWorkers: 2,
+				HistoryDays:      90,
+				WebhookDryRun:    true,
+				Sources:          sources,
+				DiscoveryURL:     defaultDiscoveryURL,
+				ReplayDir:        fixtures,
+				OutputDir:        output,
+			}
+			if err := crawlServices(context.Background(), opts); err != nil {
+				t.Fatalf("crawlServices() error = %v", err)
+			}
+
+			directory, err := readDirectory(filepath.Join(output, "directory.json"))
+			if err != nil {
+				t.Fatalf("failed to read the replayed directory.json: %v", err)
+			}
+			var ids []string
+			for _, api := range directory.Items {
+				ids = append(ids, api.ID)
+				if api.FirstSeen == "" || api.LastSeen == "" {
+					t.Errorf("API %s has no seen dates", api.ID)
+				}
+				if _, err := os.Stat(discoveryPathIn(filepath.Join(output, discoveryDir), api.ID)); err != nil {
+					t.Errorf("discovery document of %s was not published: %v", api.ID, err)
+				}
+			}
+			if want := []string{"pubsub:v1", "storage:v1"}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("replayed APIs = %v, want %v", ids, want)
+			}
+
+			metadata, err := readCrawlMetadata(filepath.Join(output, crawlMetadataFile))
+			if err != nil {
+				t.Fatalf("failed to read the replayed %s: %v", crawlMetadataFile, err)
+			}
+			if want := (CrawlCounts{APIs: 2, DiscoveryDocuments: 2}); metadata.Counts != want {
+				t.Errorf("metadata counts = %+v, want %+v", metadata.Counts, want)
+			}
+			for _, status := range metadata.Sources {
+				if !status.Succeeded {
+					t.Errorf("source %s failed: %s", status.Name, status.Error)
+				}
+			}
+			history, err := readSeenHistory(filepath.Join(output, seenHistoryFile))
+			if err != nil {
+				t.Fatalf("failed to read the replayed history: %v", err)
+			}
+			if len(history.APIs) != 2 {
+				t.Errorf("replayed history has %d APIs, want 2", len(history.APIs))
+			}
+			if _, err := os.Stat(filepath.Join(output, checkpointDir)); !os.IsNotExist(err) {
+				t.Errorf("replay left its checkpoint in the output: %v", err)
+			}
+
+			want := tt.repo
+			if want == nil {
+				want = map[string]string{}
+			}
+			if got := readTree(t, repo); !reflect.DeepEqual(got, want) {
+				var names []string
+				for name := range got {
+					names = append(names, name)
+				}
+				t.Errorf("replay changed the repository, which now holds %v", names)
+			}
+		})
+	}
+}
+
+func TestReplayCrawlMissingFixture(t *testing.T) {
+	// A fixtures directory that recorded nothing but its manifest.
+	fixtures := t.TempDir()
+	if err := writeFixture(filepath.Join(fixtures, fixtureManifestFile), FixtureManifest{Project: "catalog-crawl"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GCP_PROJECT_ID", "catalog-crawl")
+	t.Chdir(t.TempDir())
+
+	sources, err := selectSources("directory,discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := crawlOptions{
+		MaxShrink:        10,
+		DiscoveryWorkers: 2,
+		WebhookDryRun:    true,
+		Sources:          sources,
+		DiscoveryURL:     defaultDiscoveryURL,
+		ReplayDir:        fixtures,
+		OutputDir:        t.TempDir(),
+	}
+	start := time.Now()
+	if err := crawlServices(context.Background(), opts); err == nil {
+		t.Fatal("crawlServices() succeeded without fixtures")
+	}
+	// A missing fixture is not retried with backoff.
+	if elapsed := time.Since(start); elapsed >= initialBackoff {
+		t.Errorf("crawlServices() took %s, want the missing fixture to fail without retrying", elapsed)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyDir holds the crawl history committed alongside the crawl output.
+const historyDir = "history"
+
+// seenHistoryFile records when every service and API was first and last crawled.
+var seenHistoryFile = filepath.Join(historyDir, "seen.json")
+
+// seenHistorySchemaVersion is the version of the seen.json format.
+const seenHistorySchemaVersion = 1
+
+// historyDateFormat is the layout of the dates stored in the history.
+const historyDateFormat = "2006-01-02"
+
+// SeenHistory records the first and last crawl dates of every service and API,
+// including the ones that are no longer returned.
+type SeenHistory struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Services      map[string]SeenDates `json:"services"`
+	APIs          map[string]SeenDates `json:"apis"`
+}
+
+// SeenDates holds the first and last dates an entry was crawled.
+type SeenDates struct {
+	FirstSeen string `json:"firstSeen"`
+	LastSeen  string `json:"lastSeen"`
+}
+
+// historyDate formats a time as a history date in UTC.
+func historyDate(t time.Time) string {
+	return t.UTC().Format(historyDateFormat)
+}
+
+// readSeenHistory reads the seen history, returning an empty history when it does not exist yet.
+func readSeenHistory(path string) (*SeenHistory, error) {
+	history := &SeenHistory{
+		SchemaVersion: seenHistorySchemaVersion,
+		Services:      make(map[string]SeenDates),
+		APIs:          make(map[string]SeenDates),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return history, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if history.SchemaVersion > seenHistorySchemaVersion {
+		return nil, fmt.Errorf("%s schema version %d is newer than the supported version %d",
+			path, history.SchemaVersion, seenHistorySchemaVersion)
+	}
+	if history.Services == nil {
+		history.Services = make(map[string]SeenDates)
+	}
+	if history.APIs == nil {
+		history.APIs = make(map[string]SeenDates)
+	}
+	return history, nil
+}
+
+// writeSeenHistory writes the seen history, creating the history folder if needed.
+func writeSeenHistory(path string, history *SeenHistory) error {
+	history.SchemaVersion = seenHistorySchemaVersion
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+	jsonData, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history JSON: %v", err)
+	}
+	if err := writeFileAtomic(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// markSeen records that the entry with the given key was crawled on date.
+func markSeen(seen map[string]SeenDates, key, date string) SeenDates {
+	dates := seen[key]
+	if dates.FirstSeen == "" {
+		dates.FirstSeen = date
+	}
+	dates.LastSeen = date
+	seen[key] = dates
+	return dates
+}
+
+// MarkServices records the services as crawled on date and stamps them with their seen dates.
+func (h *SeenHistory) MarkServices(services []Service, date string) {
+	for i := range services {
+		dates := markSeen(h.Services, services[i].Name, date)
+		services[i].FirstSeen = dates.FirstSeen
+		services[i].LastSeen = dates.LastSeen
+	}
+}
+
+// MarkAPIs records the APIs as crawled on date and stamps them with their seen dates.
+func (h *SeenHistory) MarkAPIs(apis []APIEntry, date string) {
+	for i := range apis {
+		dates := markSeen(h.APIs, apis[i].ID, date)
+		apis[i].FirstSeen = dates.FirstSeen
+		apis[i].LastSeen = dates.LastSeen
+	}
+}
+
+// snapshotDir holds a compact snapshot of the crawl results for every crawl date.
+var snapshotDir = filepath.Join(historyDir, "snapshots")
+
+// snapshotSchemaVersion is the version of the snapshot format.
+const snapshotSchemaVersion = 1
+
+// recordSnapshot writes a snapshot of the current services.json and directory.json for
+// date, replacing an earlier snapshot from the same day, and removes the snapshots
+// older than keepDays. A keepDays of zero keeps every snapshot.
+func recordSnapshot(date string, keepDays int) error {
+	catalog, err := readServiceCatalog("services.json")
+	if err != nil {
+		return fmt.Errorf("failed to read services.json: %v", err)
+	}
+	directory, err := readDirectory("directory.json")
+	if err != nil {
+		return fmt.Errorf("failed to read directory.json: %v", err)
+	}
+
+	if err := writeSnapshot(snapshotDir, newSnapshot(date, catalog.Services, directory.Items)); err != nil {
+		return err
+	}
+
+	if keepDays > 0 {
+		day, err := time.Parse(historyDateFormat, date)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot date %q: %v", date, err)
+		}
+		cutoff := historyDate(day.AddDate(0, 0, -keepDays))
+		if err := pruneSnapshots(snapshotDir, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSnapshot writes a snapshot into dir as <date>.json.
+func writeSnapshot(dir string, snapshot *Snapshot) error {
+	snapshot.SchemaVersion = snapshotSchemaVersion
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+	// Snapshots are not indented to keep them compact.
+	jsonData, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot JSON: %v", err)
+	}
+	path := filepath.Join(dir, snapshot.Date+".json")
+	if err := writeFileAtomic(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// readSnapshots reads every snapshot in dir, oldest first. A missing folder has no snapshots.
+func readSnapshots(dir string) ([]*Snapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	// The file names are dates, so sorting them sorts the snapshots by date.
+	sort.Strings(paths)
+
+	var snapshots []*Snapshot
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if snapshot.SchemaVersion > snapshotSchemaVersion {
+			return nil, fmt.Errorf("%s schema version %d is newer than the supported version %d",
+				path, snapshot.SchemaVersion, snapshotSchemaVersion)
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+	return snapshots, nil
+}
+
+// pruneSnapshots removes the snapshots in dir dated before cutoff.
+func pruneSnapshots(dir, cutoff string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if strings.TrimSuffix(filepath.Base(path), ".json") >= cutoff {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove old snapshot %s: %v", path, err)
+		}
+		log.Printf("Removed old snapshot %s", path)
+	}
+	return nil
+}
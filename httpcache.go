@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Folders inside the HTTP cache directory.
+const (
+	httpCacheEntries = "entries"
+	httpCacheBlobs   = "blobs"
+)
+
+// HTTPCacheEntry records the validators of a cached response and the blob holding its body.
+type HTTPCacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	Blob         string    `json:"blob"`
+	StoredAt     time.Time `json:"storedAt"`
+}
+
+// httpCache stores response bodies on disk by the SHA-256 of their content, so
+// documents shared by several URLs or unchanged between crawls are stored once,
+// with an entry per URL pointing at its body.
+type httpCache struct {
+	dir string
+
+	mu sync.Mutex
+	// used holds the entry files of the URLs requested during this crawl.
+	used map[string]bool
+
+	downloaded  atomic.Int64
+	revalidated atomic.Int64
+}
+
+// newHTTPCache opens, creating if needed, the cache in dir.
+func newHTTPCache(dir string) (*httpCache, error) {
+	for _, sub := range []string{httpCacheEntries, httpCacheBlobs} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), os.ModePerm); err != nil {
+			return nil, fmt.Errorf("failed to create HTTP cache directory: %v", err)
+		}
+	}
+	return &httpCache{dir: dir, used: make(map[string]bool)}, nil
+}
+
+// sha256Hex returns the hex encoded SHA-256 of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *httpCache) entryPath(url string) string {
+	return filepath.Join(c.dir, httpCacheEntries, sha256Hex([]byte(url))+".json")
+}
+
+func (c *httpCache) blobPath(hash string) string {
+	return filepath.Join(c.dir, httpCacheBlobs, hash)
+}
+
+// markUsed records that a URL was requested during this crawl.
+func (c *httpCache) markUsed(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used[filepath.Base(c.entryPath(url))] = true
+}
+
+// lookup returns the entry and body cached for a URL, or nil when there is none.
+func (c *httpCache) lookup(url string) (*HTTPCacheEntry, []byte) {
+	data, err := os.ReadFile(c.entryPath(url))
+	if err != nil {
+		return nil, nil
+	}
+	var entry HTTPCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil, nil
+	}
+	body, err := os.ReadFile(c.blobPath(entry.Blob))
+	// A body that does not match its hash is treated as missing.
+	if err != nil || sha256Hex(body) != entry.Blob {
+		return nil, nil
+	}
+	return &entry, body
+}
+
+// store saves a response body and its validators. Responses without an ETag or
+// Last-Modified header cannot be revalidated and are not stored.
+func (c *httpCache) store(url string, header http.Header, body []byte) error {
+	entry := HTTPCacheEntry{
+		URL:          url,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		ContentType:  header.Get("Content-Type"),
+		Blob:         sha256Hex(body),
+		StoredAt:     time.Now().UTC(),
+	}
+	if entry.ETag == "" && entry.LastModified == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(c.blobPath(entry.Blob)); err != nil {
+		if err := writeFileAtomic(c.blobPath(entry.Blob), body, 0644); err != nil {
+			return fmt.Errorf("failed to write cached body: %v", err)
+		}
+	}
+	jsonData, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %v", err)
+	}
+	if err := writeFileAtomic(c.entryPath(url), jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	return nil
+}
+
+// prune removes the bodies no entry points at any more. When dropUnused is set, the
+// entries of URLs that were not requested during this crawl, such as the documents of
+// APIs that left the directory, are removed first.
+func (c *httpCache) prune(dropUnused bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(c.dir, httpCacheEntries, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list cache entries: %v", err)
+	}
+	keep := make(map[string]bool, len(paths))
+	dropped := 0
+	for _, path := range paths {
+		if dropUnused && !c.used[filepath.Base(path)] {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove unused cache entry: %v", err)
+			}
+			dropped++
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry HTTPCacheEntry
+		if err := json.Unmarshal(data, &entry); err == nil {
+			keep[entry.Blob] = true
+		}
+	}
+
+	blobs, err := os.ReadDir(filepath.Join(c.dir, httpCacheBlobs))
+	if err != nil {
+		return fmt.Errorf("failed to list cached bodies: %v", err)
+	}
+	removed := 0
+	for _, blob := range blobs {
+		if keep[blob.Name()] {
+			continue
+		}
+		if err := os.Remove(c.blobPath(blob.Name())); err != nil {
+			return fmt.Errorf("failed to remove cached body: %v", err)
+		}
+		removed++
+	}
+	log.Printf("HTTP cache: %d downloaded, %d unchanged, %d unused entries and %d stale bodies removed",
+		c.downloaded.Load(), c.revalidated.Load(), dropped, removed)
+	return nil
+}
+
+// cachingTransport revalidates cached GET responses with If-None-Match and
+// If-Modified-Since, answering from the cache when the server replies 304 Not Modified.
+type cachingTransport struct {
+	cache *httpCache
+	next  http.RoundTripper
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || (req.URL.Scheme != "http" && req.URL.Scheme != "https") {
+		return t.next.RoundTrip(req)
+	}
+
+	url := req.URL.String()
+	t.cache.markUsed(url)
+	entry, cached := t.cache.lookup(url)
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		t.cache.revalidated.Add(1)
+
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		if entry.ContentType != "" {
+			resp.Header.Set("Content-Type", entry.ContentType)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(cached))
+		resp.ContentLength = int64(len(cached))
+		return resp, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	t.cache.downloaded.Add(1)
+	if err := t.cache.store(url, resp.Header, body); err != nil {
+		log.Printf("Warning: failed to cache %s: %v", url, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// rateLimitedTransport spaces out the requests it sends over the network so the
+// crawler stays polite to the servers.
+type rateLimitedTransport struct {
+	interval time.Duration
+	next     http.RoundTripper
+
+	mu     sync.Mutex
+	sendAt time.Time
+}
+
+// newRateLimitedTransport allows up to perSecond requests a second, or any number when it is zero.
+func newRateLimitedTransport(perSecond float64, next http.RoundTripper) http.RoundTripper {
+	if perSecond <= 0 {
+		return next
+	}
+	return &rateLimitedTransport{interval: time.Duration(float64(time.Second) / perSecond), next: next}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" || req.URL.Scheme == "https" {
+		t.mu.Lock()
+		now := time.Now()
+		wait := t.sendAt.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		t.sendAt = now.Add(wait + t.interval)
+		t.mu.Unlock()
+
+		if wait > 0 {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// withJitter returns a random duration between half and all of d, so clients
+// retrying at the same time spread out.
+func withJitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + rand.N(half+1)
+}
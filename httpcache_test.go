@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPruneAfterResumedDiscoveryCrawl(t *testing.T) {
+	// Every document has an ETag, so the cache stores it for revalidation.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"` + r.URL.Path + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "` + strings.TrimPrefix(r.URL.Path, "/") + `"}`))
+	}))
+	defer server.Close()
+
+	apis := []APIEntry{
+		{ID: "a:v1", DiscoveryRestURL: server.URL + "/a:v1"},
+		{ID: "b:v1", DiscoveryRestURL: server.URL + "/b:v1"},
+	}
+	// An API that has since left the directory.
+	removedURL := server.URL + "/c:v1"
+
+	// An earlier crawl cached every document.
+	opts := crawlOptions{HTTPCacheDir: t.TempDir(), DiscoveryWorkers: 2, OutputDir: t.TempDir()}
+	client, _, err := newCrawlHTTPClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{apis[0].DiscoveryRestURL, apis[1].DiscoveryRestURL, removedURL} {
+		if _, err := fetchURL(context.Background(), client, url); err != nil {
+			t.Fatalf("fetchURL(%s) error = %v", url, err)
+		}
+	}
+
+	// The crawl is resumed after it saved the document of a:v1.
+	checkpoint, err := newCheckpoint(filepath.Join(t.TempDir(), checkpointDir), "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory, err := json.Marshal(DirectoryList{Items: apis})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(checkpoint.outputPath("directory.json"), directory, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.markDocumentFetched("a:v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	client, cache, err := newCrawlHTTPClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &crawlEnv{Options: opts, Client: client, Cache: cache, Checkpoint: checkpoint}
+	if _, err := (discoveryDocumentsSource{}).Fetch(context.Background(), env); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if err := cache.prune(true); err != nil {
+		t.Fatalf("prune() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		url  string
+		want bool
+	}{
+		{url: apis[0].DiscoveryRestURL, want: true},
+		{url: apis[1].DiscoveryRestURL, want: true},
+		{url: removedURL, want: false},
+	} {
+		entry, _ := cache.lookup(tt.url)
+		if got := entry != nil; got != tt.want {
+			t.Errorf("cache entry for %s kept = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
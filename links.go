@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// serviceAPIOverridesFile maps API names or IDs to the name of the service that owns them,
+// for the APIs the matching heuristics get wrong. An empty service name unlinks the API.
+const serviceAPIOverridesFile = "service-api-overrides.json"
+
+// sharedAPIHost is the legacy host shared by many APIs, so it never identifies a service.
+const sharedAPIHost = "www.googleapis.com"
+
+// readServiceAPIOverrides reads the overrides file, returning no overrides when it does not exist.
+func readServiceAPIOverrides(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return overrides, nil
+}
+
+// apiServiceCandidates returns the service names an API may belong to, most likely first:
+// the host of its discovery document root URL, the host of its discovery URL and its name.
+func apiServiceCandidates(api APIEntry) []string {
+	var candidates []string
+	addHost := func(rawURL string) {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Hostname() == "" || u.Hostname() == sharedAPIHost {
+			return
+		}
+		candidates = append(candidates, u.Hostname())
+	}
+
+	if api.Discovery != nil {
+		addHost(api.Discovery.RootURL)
+	}
+	addHost(api.DiscoveryRestURL)
+	if api.Name != "" {
+		candidates = append(candidates, strings.ToLower(api.Name)+".googleapis.com")
+	}
+	return candidates
+}
+
+// matchAPIService returns the name of the service that owns an API, or "" when no known
+// service matches. Overrides by API ID take precedence over overrides by API name.
+func matchAPIService(api APIEntry, serviceNames map[string]bool, overrides map[string]string) string {
+	if name, ok := overrides[api.ID]; ok {
+		return name
+	}
+	if name, ok := overrides[api.Name]; ok {
+		return name
+	}
+
+	for _, candidate := range apiServiceCandidates(api) {
+		if serviceNames[candidate] {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// linkServicesAndAPIs points every API at the service that owns it and lists the
+// APIs of each service, in the order of apis.
+func linkServicesAndAPIs(services []Service, apis []APIEntry, overrides map[string]string) {
+	serviceIndex := make(map[string]int, len(services))
+	serviceNames := make(map[string]bool, len(services))
+	for i, svc := range services {
+		serviceIndex[svc.Name] = i
+		serviceNames[svc.Name] = true
+	}
+
+	for i := range apis {
+		name := matchAPIService(apis[i], serviceNames, overrides)
+		index, ok := serviceIndex[name]
+		if !ok {
+			continue
+		}
+		apis[i].Service = &services[index]
+		services[index].RelatedAPIs = append(services[index].RelatedAPIs, apis[i])
+	}
+}
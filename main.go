@@ -4,19 +4,30 @@ import (
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	serviceusage "cloud.google.com/go/serviceusage/apiv1"
 	serviceusagepb "cloud.google.com/go/serviceusage/apiv1/serviceusagepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 // Service represents a simplified GCP service configuration.
@@ -25,8 +36,23 @@ type Service struct {
 	Title         string `json:"title"`
 	Documentation string `json:"documentation,omitempty"`
 	Domain        string `json:"domain,omitempty"`
+	// FirstSeen and LastSeen are the first and last crawl dates recorded in the history.
+	FirstSeen string `json:"firstSeen,omitempty"`
+	LastSeen  string `json:"lastSeen,omitempty"`
+	// State is whether the service was ENABLED or DISABLED in Project, the project it was crawled in.
+	State   string `json:"state,omitempty"`
+	Project string `json:"project,omitempty"`
+	// The remaining sections are copied from the service configuration.
+	APIs               []ServiceAPI           `json:"apis,omitempty"`
+	Endpoints          []ServiceEndpoint      `json:"endpoints,omitempty"`
+	Authentication     *ServiceAuthentication `json:"authentication,omitempty"`
+	Usage              *ServiceUsage          `json:"usage,omitempty"`
+	MonitoredResources []MonitoredResource    `json:"monitoredResources,omitempty"`
+	Monitoring         *ServiceMonitoring     `json:"monitoring,omitempty"`
 	// FileName is not saved in JSON; it is computed for linking pages.
 	FileName string `json:"-"`
+	// RelatedAPIs is not saved in JSON; it lists the directory APIs served by this service.
+	RelatedAPIs []APIEntry `json:"-"`
 }
 
 // DirectoryList represents the main structure containing the API directory information.
@@ -50,8 +76,15 @@ type APIEntry struct {
 	Preferred         bool     `json:"preferred"`
 	Title             string   `json:"title"`
 	Version           string   `json:"version"`
+	// FirstSeen and LastSeen are the first and last crawl dates recorded in the history.
+	FirstSeen string `json:"firstSeen,omitempty"`
+	LastSeen  string `json:"lastSeen,omitempty"`
 	// FileName is not saved in JSON; it is computed for linking pages.
 	FileName string `json:"-"`
+	// Discovery is not saved in JSON; it is loaded from the discovery folder.
+	Discovery *DiscoveryDocument `json:"-"`
+	// Service is not saved in JSON; it is the service that owns this API, if one matches.
+	Service *Service `json:"-"`
 }
 
 // Icons represents the icon URLs for an API.
@@ -75,6 +108,47 @@ type Sitemap struct {
 	URLs    []SitemapURL `xml:"url"`
 }
 
+// crawlOptions holds the settings that control a crawl.
+type crawlOptions struct {
+	// MaxShrink is the largest percentage of entries a crawl may drop before the previous file is kept.
+	MaxShrink float64
+	// DiscoveryWorkers is the number of discovery documents fetched concurrently.
+	DiscoveryWorkers int
+	// HistoryDays is the number of days of snapshots kept in the history, or zero to keep all.
+	HistoryDays int
+	// Webhooks are notified when the crawl adds or removes services or flips preferred APIs.
+	Webhooks []Webhook
+	// WebhookDryRun logs the webhook payloads instead of posting them.
+	WebhookDryRun bool
+	// Sources are crawled in order.
+	Sources []Source
+	// ServiceUsageEndpoint is the host:port of the Service Usage API, or empty for production.
+	ServiceUsageEndpoint string
+	// ServiceUsageInsecure connects to ServiceUsageEndpoint over plaintext without credentials.
+	ServiceUsageInsecure bool
+	// DiscoveryURL is the http, https or file URL of the API directory.
+	DiscoveryURL string
+	// HTTPCacheDir, when set, is where HTTP responses are cached for revalidation.
+	HTTPCacheDir string
+	// HTTPTimeout limits each HTTP request, or zero for no limit.
+	HTTPTimeout time.Duration
+	// HTTPRate is the largest number of HTTP requests sent per second, or zero for no limit.
+	HTTPRate float64
+	// Only, when set, names the services refreshed and merged into services.json
+	// instead of listing every service.
+	Only []string
+	// Resume continues the crawl recorded in the checkpoint instead of starting over.
+	Resume bool
+	// CheckpointMaxAge is how old a checkpoint may be and still be resumed, or zero for any age.
+	CheckpointMaxAge time.Duration
+	// RecordDir, when set, is where every Service Usage and HTTP response is saved.
+	RecordDir string
+	// ReplayDir, when set, holds recorded responses that answer the requests instead of the APIs.
+	ReplayDir string
+	// OutputDir is where the crawled files and the crawl metadata are published.
+	OutputDir string
+}
+
 // RobotsTxt represents the data needed by the robots.txt template.
 type RobotsTxt struct {
 	SitemapURL string
@@ -85,234 +159,843 @@ func main() {
 	// Command-line flags.
 	crawlFlag := flag.Bool("crawl", false, "Crawl GCP service usage and save service details to services.json")
 	generateFlag := flag.Bool("generate", false, "Generate HTML pages from saved services.json data")
+	diffFlag := flag.Bool("diff", false, "Compare two catalogs, each a folder or git revision: -diff OLD NEW")
+	fakeServerFlag := flag.Bool("fake-server", false, "Serve services.json and directory.json as fake Service Usage and Discovery APIs")
+	formatFlag := flag.String("format", "text", "Output format of -diff: text, markdown or json")
+	maxShrinkFlag := flag.Float64("max-shrink", 10, "Maximum percentage of entries a crawl may drop before the previous file is kept")
+	discoveryWorkersFlag := flag.Int("discovery-workers", 8, "Number of discovery documents to fetch concurrently")
+	historyDaysFlag := flag.Int("history-days", 90, "Number of days of crawl snapshots to keep, or 0 to keep all")
+	webhookFlag := flag.String("webhook", os.Getenv("WEBHOOK_URLS"), "Comma-separated URLs that receive a JSON change summary after a crawl (default $WEBHOOK_URLS)")
+	slackWebhookFlag := flag.String("slack-webhook", os.Getenv("SLACK_WEBHOOK_URLS"), "Comma-separated Slack incoming webhook URLs notified after a crawl (default $SLACK_WEBHOOK_URLS)")
+	webhookDryRunFlag := flag.Bool("webhook-dry-run", false, "Log the webhook payloads instead of posting them")
+	serviceUsageEndpointFlag := flag.String("serviceusage-endpoint", os.Getenv("SERVICEUSAGE_ENDPOINT"), "host:port of the Service Usage API to crawl instead of production (default $SERVICEUSAGE_ENDPOINT)")
+	serviceUsageInsecureFlag := flag.Bool("serviceusage-insecure", false, "Connect to -serviceusage-endpoint over plaintext gRPC without credentials")
+	discoveryURLFlag := flag.String("discovery-url", defaultDiscoveryURL, "URL of the API directory to crawl; file:// URLs read local copies")
+	fakeGRPCAddrFlag := flag.String("fake-grpc-addr", "localhost:8085", "Address the -fake-server Service Usage gRPC API listens on")
+	fakeHTTPAddrFlag := flag.String("fake-http-addr", "localhost:8086", "Address the -fake-server Discovery HTTP API listens on")
+	httpCacheFlag := flag.String("http-cache", ".http-cache", "Directory caching directory and discovery responses for revalidation, or empty to disable")
+	httpTimeoutFlag := flag.Duration("http-timeout", 60*time.Second, "Timeout of each directory and discovery request")
+	httpRateFlag := flag.Float64("http-rate", 10, "Largest number of directory and discovery requests per second, or 0 for no limit")
+	timeoutFlag := flag.Duration("timeout", 0, "Stop the crawl after this long, keeping the checkpoint for -resume, or 0 for no limit")
+	onlyFlag := flag.String("only", "", "Comma-separated service names to refresh and merge into services.json instead of listing every service")
+	resumeFlag := flag.Bool("resume", false, "Continue the crawl left unfinished in "+checkpointDir+" instead of starting over")
+	checkpointMaxAgeFlag := flag.Duration("checkpoint-max-age", 36*time.Hour, "Start over instead of resuming a checkpoint started longer ago than this, or 0 to resume any")
+	recordFlag := flag.String("record", "", "Save every Service Usage and HTTP response of the crawl as fixtures in this directory")
+	replayFlag := flag.String("replay", "", "Crawl from the fixtures saved by -record in this directory instead of the APIs")
+	outputFlag := flag.String("output", "", "Directory -replay publishes the crawled files to, leaving the repository as it is (required with -replay)")
+	sourcesFlag := flag.String("sources", "", "Comma-separated sources to crawl, of "+strings.Join(sourceNames(), ", ")+" (default all)")
 	flag.Parse()
 
-	if *crawlFlag && *generateFlag {
-		log.Fatal("Please specify only one command: -crawl or -generate")
+	commands := 0
+	for _, set := range []bool{*crawlFlag, *generateFlag, *diffFlag, *fakeServerFlag} {
+		if set {
+			commands++
+		}
 	}
-	if !*crawlFlag && !*generateFlag {
+	if commands > 1 {
+		log.Fatal("Please specify only one command: -crawl, -generate, -diff or -fake-server")
+	}
+	if commands == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	if *crawlFlag {
-		if err := crawlServices(); err != nil {
+		sources, err := selectSources(*sourcesFlag)
+		if err != nil {
+			log.Fatalf("Invalid -sources: %v", err)
+		}
+		only := parseServiceNames(*onlyFlag)
+		if len(only) > 0 {
+			// Refreshing a few services only updates services.json, outside of any checkpoint.
+			if *sourcesFlag != "" && (len(sources) != 1 || sources[0].Name() != (serviceUsageSource{}).Name()) {
+				log.Fatal("-only only refreshes the serviceusage source")
+			}
+			if *resumeFlag {
+				log.Fatal("Please specify only one of -only and -resume")
+			}
+		}
+		if *serviceUsageInsecureFlag && *serviceUsageEndpointFlag == "" {
+			log.Fatal("-serviceusage-insecure requires -serviceusage-endpoint")
+		}
+		if *recordFlag != "" && *replayFlag != "" {
+			log.Fatal("Please specify only one of -record and -replay")
+		}
+		if *outputFlag != "" && *replayFlag == "" {
+			log.Fatal("-output is only supported with -replay")
+		}
+		webhookDryRun := *webhookDryRunFlag
+		httpCacheDir := *httpCacheFlag
+		outputDir := "."
+		if *replayFlag != "" {
+			// A replay reproduces a past run, so it publishes somewhere else than the
+			// committed data and never continues or refreshes the crawl of the repository.
+			if *outputFlag == "" {
+				log.Fatal("-replay requires -output")
+			}
+			if *resumeFlag || len(only) > 0 {
+				log.Fatal("-replay cannot be combined with -resume or -only")
+			}
+			manifest, err := readFixtureManifest(*replayFlag)
+			if err != nil {
+				log.Fatalf("Invalid -replay: %v", err)
+			}
+			// The recorded requests name the project, so replay them for the same one.
+			if os.Getenv("GCP_PROJECT_ID") == "" {
+				os.Setenv("GCP_PROJECT_ID", manifest.Project)
+			}
+			// A replayed crawl must not notify anyone, and as every request is answered
+			// from the fixtures the HTTP cache would only be pruned.
+			webhookDryRun = true
+			httpCacheDir = ""
+			outputDir = *outputFlag
+		}
+		opts := crawlOptions{
+			MaxShrink:        *maxShrinkFlag,
+			DiscoveryWorkers: *discoveryWorkersFlag,
+			HistoryDays:      *historyDaysFlag,
+			Webhooks:         parseWebhooks(*webhookFlag, *slackWebhookFlag),
+			WebhookDryRun:    webhookDryRun,
+			Sources:          sources,
+
+			ServiceUsageEndpoint: *serviceUsageEndpointFlag,
+			ServiceUsageInsecure: *serviceUsageInsecureFlag,
+			DiscoveryURL:         *discoveryURLFlag,
+			HTTPCacheDir:         httpCacheDir,
+			HTTPTimeout:          *httpTimeoutFlag,
+			HTTPRate:             *httpRateFlag,
+			Only:                 only,
+			Resume:               *resumeFlag,
+			CheckpointMaxAge:     *checkpointMaxAgeFlag,
+			RecordDir:            *recordFlag,
+			ReplayDir:            *replayFlag,
+			OutputDir:            outputDir,
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if *timeoutFlag > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeoutFlag)
+			defer cancel()
+		}
+		if len(opts.Only) > 0 {
+			if err := refreshCatalog(ctx, opts); err != nil {
+				log.Fatalf("Refresh failed: %v", err)
+			}
+		} else if err := crawlServices(ctx, opts); err != nil {
 			log.Fatalf("Crawl failed: %v", err)
 		}
 	} else if *generateFlag {
 		if err := generateHTML(); err != nil {
 			log.Fatalf("Generate failed: %v", err)
 		}
+	} else if *diffFlag {
+		// The exit code tells scripts whether anything changed, so errors use their own code.
+		if flag.NArg() != 2 {
+			log.Printf("Usage: -diff [-format text|markdown|json] OLD NEW")
+			os.Exit(diffExitError)
+		}
+		changed, err := diffCatalogs(flag.Arg(0), flag.Arg(1), *formatFlag, os.Stdout)
+		if err != nil {
+			log.Printf("Diff failed: %v", err)
+			os.Exit(diffExitError)
+		}
+		if changed {
+			os.Exit(diffExitChanged)
+		}
+	} else if *fakeServerFlag {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runFakeServer(ctx, *fakeGRPCAddrFlag, *fakeHTTPAddrFlag); err != nil {
+			log.Fatalf("Fake server failed: %v", err)
+		}
 	}
 }
 
 // crawlServices contacts the Service Usage API and writes a services.json file.
-// It also fetches the Google API Directory and writes a directory.json file.
-func crawlServices() error {
-	ctx := context.Background()
+// It also fetches the Google API Directory and writes a directory.json file,
+// along with the discovery document of every API into the discovery folder.
+// The sources write into the checkpoint, and their files are only moved into place
+// once every source succeeded, so a failed or interrupted crawl keeps the previous
+// files and returns an error.
+// The dates each service and API were first and last crawled, and a snapshot of
+// every crawl, are kept in the history folder.
+// A replay publishes its files and metadata into opts.OutputDir instead, keeping its
+// checkpoint there, and records no snapshot, so the repository is left as it is.
+func crawlServices(ctx context.Context, opts crawlOptions) error {
+	metadata := &CrawlMetadata{
+		StartedAt: time.Now().UTC(),
+		Project:   os.Getenv("GCP_PROJECT_ID"),
+		Build:     currentBuildMetadata(),
+	}
+	var failures []string
+
+	replay := opts.ReplayDir != ""
+
+	// Start a new checkpoint, or continue the one left by an unfinished crawl.
+	var checkpoint *CrawlCheckpoint
+	var err error
+	if opts.Resume {
+		checkpoint, err = resumeCheckpoint(checkpointDir, metadata.Project, opts.CheckpointMaxAge)
+	} else {
+		checkpoint, err = newCheckpoint(filepath.Join(opts.OutputDir, checkpointDir), metadata.Project)
+	}
+	if err != nil {
+		return err
+	}
 
-	// Crawl service usage API
-	serviceUsageErr := crawlServiceUsage(ctx)
-	if serviceUsageErr != nil {
-		log.Printf("Warning: service usage crawl failed: %v", serviceUsageErr)
-		// We'll continue with the API directory crawl even if service usage fails
+	// Remember the catalog before the crawl so the webhooks can be told what changed.
+	// A resumed crawl compares against the catalog from when it first started.
+	before, err := checkpoint.baseline()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if before == nil {
+		before, err = loadCatalogSnapshot(".")
+		if err != nil {
+			log.Printf("Warning: no previous catalog to compare against, webhooks will not be notified: %v", err)
+		} else if err := checkpoint.saveBaseline(before); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	// Load the history so the crawled entries can be stamped with their seen dates,
+	// continuing from the dates staged by a resumed crawl.
+	historyPath := checkpoint.outputPath(seenHistoryFile)
+	if _, err := os.Stat(historyPath); err != nil {
+		historyPath = seenHistoryFile
+	}
+	history, err := readSeenHistory(historyPath)
+	if err != nil {
+		log.Printf("Warning: failed to read %s, seen dates will not be updated: %v", historyPath, err)
 	}
 
-	// Crawl API directory
-	if err := crawlAPIDirectory(); err != nil {
-		return fmt.Errorf("failed to crawl API directory: %v", err)
+	client, cache, err := newCrawlHTTPClient(opts)
+	if err != nil {
+		return err
+	}
+
+	env := &crawlEnv{
+		Options:             opts,
+		Client:              client,
+		Cache:               cache,
+		ServiceUsageOptions: serviceUsageClientOptions(opts.ServiceUsageEndpoint, opts.ServiceUsageInsecure),
+		History:             history,
+		Checkpoint:          checkpoint,
+		Metadata:            metadata,
+	}
+	if err := setupFixtures(env); err != nil {
+		return err
+	}
+
+	// Crawl every selected source even if an earlier one failed so a resumed crawl
+	// only has to retry the ones that failed.
+	fetched := 0
+	for _, source := range opts.Sources {
+		// Sources left when the crawl is interrupted are recorded as not run.
+		if err := ctx.Err(); err != nil {
+			metadata.Sources = append(metadata.Sources, newSourceStatus(source, time.Now(), 0, err))
+			continue
+		}
+		if count, ok := checkpoint.sourceDone(source.Name()); ok {
+			log.Printf("Source %s already completed before the crawl was resumed", source.Name())
+			metadata.Sources = append(metadata.Sources, newSourceStatus(source, time.Now(), count, nil))
+			continue
+		}
+
+		start := time.Now()
+		count, err := source.Fetch(ctx, env)
+		metadata.Sources = append(metadata.Sources, newSourceStatus(source, start, count, err))
+		if err != nil {
+			log.Printf("Source %s failed, keeping the existing %s: %v", source.Name(), source.Output().Path, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", source.Name(), err))
+			continue
+		}
+		fetched++
+
+		// Stage the seen dates as each source completes, as a resumed crawl skips the source.
+		if history != nil {
+			if err := writeSeenHistory(checkpoint.outputPath(seenHistoryFile), history); err != nil {
+				log.Printf("Failed to write %s: %v", seenHistoryFile, err)
+				failures = append(failures, fmt.Sprintf("history: %v", err))
+				continue
+			}
+		}
+		if err := checkpoint.markSourceDone(source.Name(), count); err != nil {
+			log.Printf("Warning: failed to checkpoint source %s: %v", source.Name(), err)
+		}
+	}
+
+	if cache != nil {
+		// Only a crawl that fetched every source knows which cached URLs are still needed;
+		// a source completed before a resume made requests this run did not see.
+		if err := cache.prune(fetched == len(registeredSources)); err != nil {
+			log.Printf("Warning: failed to prune the HTTP cache: %v", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		log.Printf("Crawl interrupted: %v", ctx.Err())
+		failures = append(failures, fmt.Sprintf("interrupted: %v", ctx.Err()))
+	}
+
+	// Move the files of the sources into place only when all of them succeeded, so the
+	// repository never holds a catalog that is partly from this crawl.
+	published := false
+	if len(failures) == 0 {
+		var paths []string
+		for _, source := range opts.Sources {
+			paths = append(paths, source.Output().Path)
+		}
+		if history != nil {
+			paths = append(paths, seenHistoryFile)
+		}
+		if err := checkpoint.publish(opts.OutputDir, paths); err != nil {
+			log.Printf("Failed to publish the crawled files: %v", err)
+			failures = append(failures, fmt.Sprintf("publish: %v", err))
+		} else {
+			published = true
+		}
+	}
+
+	if published && !replay {
+		// Snapshot the files as they now stand, including those of sources that were not
+		// selected, so they do not show up as removed entries in the changelog.
+		if err := recordSnapshot(historyDate(time.Now()), opts.HistoryDays); err != nil {
+			log.Printf("Failed to record the crawl snapshot: %v", err)
+			failures = append(failures, fmt.Sprintf("snapshot: %v", err))
+		}
+
+		if len(opts.Webhooks) > 0 && before != nil {
+			if err := notifyCatalogChanges(ctx, before, opts); err != nil {
+				log.Printf("Webhook notification failed: %v", err)
+				failures = append(failures, fmt.Sprintf("webhooks: %v", err))
+			}
+		}
 	}
 
-	// Don't return an error if only the service usage part failed
-	// as we want to consider the crawl successful if we get the API directory
+	metadata.FinishedAt = time.Now().UTC()
+	elapsed := metadata.FinishedAt.Sub(metadata.StartedAt)
+	metadata.Duration = elapsed.Round(time.Millisecond).String()
+	metadata.DurationSeconds = elapsed.Seconds()
+	metadata.Counts = currentCrawlCounts(opts.OutputDir)
+	metadataPath := filepath.Join(opts.OutputDir, crawlMetadataFile)
+	previous, err := readCrawlMetadata(metadataPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to read the previous %s: %v", metadataPath, err)
+	}
+	metadata.setUpdatedAt(previous, published)
+	if err := writeCrawlMetadata(metadataPath, metadata); err != nil {
+		log.Printf("Failed to write %s: %v", metadataPath, err)
+		failures = append(failures, fmt.Sprintf("metadata: %v", err))
+	}
+
+	// A replay cannot be resumed, so its checkpoint is never kept.
+	if !published && !replay {
+		log.Printf("Checkpoint kept in %s, run -crawl -resume to retry what did not complete", checkpointDir)
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	if err := checkpoint.remove(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
 	return nil
 }
 
-// crawlServiceUsage contacts the Service Usage API and writes a services.json file.
-func crawlServiceUsage(ctx context.Context) error {
-	client, err := serviceusage.NewClient(ctx)
+// checkShrinkage compares the identifiers of a new crawl result against the previous
+// file and refuses the update when more than maxShrink percent of the entries are gone.
+// Every missing identifier is logged so the report shows exactly what disappeared.
+func checkShrinkage(fileName string, previous, current []string, maxShrink float64) error {
+	if len(previous) == 0 || len(current) >= len(previous) {
+		return nil
+	}
+
+	drop := float64(len(previous)-len(current)) / float64(len(previous)) * 100
+	if drop <= maxShrink {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(current))
+	for _, id := range current {
+		seen[id] = true
+	}
+	var missing []string
+	for _, id := range previous {
+		if !seen[id] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+
+	log.Printf("%d entries from the previous %s are missing from the new crawl:", len(missing), fileName)
+	for _, id := range missing {
+		log.Printf("  - %s", id)
+	}
+
+	return fmt.Errorf("refusing to overwrite %s: entries dropped from %d to %d (%.1f%%), more than the allowed %.1f%%",
+		fileName, len(previous), len(current), drop, maxShrink)
+}
+
+// previousServiceNames returns the names of the services in the existing services.json at path.
+// A missing or unreadable file yields no names so the first crawl is never blocked.
+func previousServiceNames(path string) []string {
+	catalog, err := readServiceCatalog(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read previous %s: %v", path, err)
+		}
+		return nil
+	}
+
+	names := make([]string, 0, len(catalog.Services))
+	for _, svc := range catalog.Services {
+		names = append(names, svc.Name)
+	}
+	return names
+}
+
+// previousAPIIDs returns the IDs of the APIs in the existing directory.json at path.
+// A missing or unreadable file yields no IDs so the first crawl is never blocked.
+func previousAPIIDs(path string) []string {
+	directory, err := readDirectory(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read previous %s: %v", path, err)
+		}
+		return nil
+	}
+
+	ids := make([]string, 0, len(directory.Items))
+	for _, api := range directory.Items {
+		ids = append(ids, api.ID)
+	}
+	return ids
+}
+
+// readDirectory reads a directory.json file written by the crawler.
+func readDirectory(path string) (*DirectoryList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var directory DirectoryList
+	if err := json.Unmarshal(data, &directory); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &directory, nil
+}
+
+// listServicesPageSize is the largest page size accepted by ListServices.
+const listServicesPageSize = 200
+
+// Retry settings for transient API failures.
+const (
+	maxRetryAttempts = 5
+	initialBackoff   = 1 * time.Second
+	maxBackoff       = 30 * time.Second
+)
+
+// errorClass describes how the crawler should react to an error from an API call.
+type errorClass int
+
+const (
+	// errorDone means the iteration finished and there are no more results.
+	errorDone errorClass = iota
+	// errorRetryable means the call failed for a transient reason and can be retried.
+	errorRetryable
+	// errorFatal means the call failed in a way that retrying will not fix.
+	errorFatal
+)
+
+// classifyError decides whether an error ends the iteration, can be retried or is fatal.
+func classifyError(err error) errorClass {
+	if errors.Is(err, iterator.Done) {
+		return errorDone
+	}
+	// A cancelled or expired context will not recover by retrying.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return errorFatal
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return errorRetryable
+	}
+	return errorFatal
+}
+
+// withRetry calls fn until it succeeds or returns an error that is not retryable,
+// backing off exponentially between attempts. The description is used for logging.
+func withRetry(ctx context.Context, description string, fn func() error) error {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || classifyError(err) != errorRetryable {
+			return err
+		}
+		if attempt == maxRetryAttempts {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+
+		log.Printf("Retrying %s in %s (attempt %d/%d): %v", description, backoff, attempt, maxRetryAttempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// serviceUsageClientOptions returns the options that point the Service Usage client at an
+// endpoint other than production. An insecure endpoint is dialed over plaintext without
+// credentials, which is meant for local stand-ins.
+func serviceUsageClientOptions(endpoint string, insecureEndpoint bool) []option.ClientOption {
+	if endpoint == "" {
+		return nil
+	}
+	opts := []option.ClientOption{option.WithEndpoint(endpoint)}
+	if insecureEndpoint {
+		opts = append(opts,
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	}
+	return opts
+}
+
+// crawlServiceUsage contacts the Service Usage API and writes the services to path, which
+// is checked for shrinkage against the published services.json at previousPath.
+// When a history is given, each service is stamped with its first and last seen dates.
+// Every page is recorded in the checkpoint, and the pages it already holds are not listed again.
+// It returns the catalog that was written.
+func crawlServiceUsage(ctx context.Context, clientOpts []option.ClientOption, path, previousPath string, maxShrink float64, history *SeenHistory, checkpoint *CrawlCheckpoint) (*ServiceCatalog, error) {
+	client, err := serviceusage.NewClient(ctx, clientOpts...)
 	if err != nil {
-		return fmt.Errorf("failed to create service usage client: %v", err)
+		return nil, fmt.Errorf("failed to create service usage client: %v", err)
 	}
 	defer client.Close()
 
 	projectID := os.Getenv("GCP_PROJECT_ID")
 	if projectID == "" {
-		return fmt.Errorf("GCP_PROJECT_ID environment variable is required")
+		return nil, fmt.Errorf("GCP_PROJECT_ID environment variable is required")
 	}
 	parent := fmt.Sprintf("projects/%s", projectID)
 
 	// Map to hold unique services keyed by service name.
-	servicesMap := make(map[string]map[string]any)
+	servicesMap := make(map[string]Service)
 
-	// Function to call the API with the given filter.
+	// Function to call the API with the given filter, one page at a time,
+	// continuing after the pages recorded in the checkpoint.
 	callAPI := func(filter string) error {
-		req := &serviceusagepb.ListServicesRequest{
-			Parent: parent,
-			Filter: filter,
-		}
-
-		// Override the number of parts to use for the domain name.
-		overrides := map[string]int{
-			".cloud.goog": 3,
+		saved, err := checkpoint.servicePages(filter)
+		if err != nil {
+			return fmt.Errorf("failed to resume from the checkpoint: %v", err)
 		}
-
-		it := client.ListServices(ctx, req)
-		for {
-			resp, err := it.Next()
-			if err != nil {
-				// Break out if iteration is done.
-				break
+		// The filter names the state of the services it lists, for responses that leave it out.
+		state := strings.TrimPrefix(filter, "state:")
+		for _, svc := range saved {
+			if svc.State == "" {
+				svc.State = state
 			}
-
-			name := resp.Config.Name
-			// If we've already seen this service, skip it.
-			if _, exists := servicesMap[name]; exists {
-				continue
+			if _, exists := servicesMap[svc.Name]; !exists {
+				servicesMap[svc.Name] = svc
 			}
+		}
+		progress := checkpoint.pageProgress(filter)
+		if progress.Done {
+			return nil
+		}
+		if progress.Pages > 0 {
+			log.Printf("Resuming %s after page %d", filter, progress.Pages)
+		}
 
-			svc := map[string]any{
-				"name":  name,
-				"title": resp.Config.Title,
+		pageToken := progress.NextPageToken
+		for page := progress.Pages + 1; ; page++ {
+			var batch []*serviceusagepb.Service
+			var nextPageToken string
+			err := withRetry(ctx, fmt.Sprintf("%s page %d", filter, page), func() error {
+				req := &serviceusagepb.ListServicesRequest{
+					Parent: parent,
+					Filter: filter,
+				}
+				// A fresh iterator is used for every attempt as an iterator
+				// that has returned an error keeps returning it.
+				batch = nil
+				var err error
+				pager := iterator.NewPager(client.ListServices(ctx, req), listServicesPageSize, pageToken)
+				nextPageToken, err = pager.NextPage(&batch)
+				return err
+			})
+			if err != nil && classifyError(err) != errorDone {
+				return fmt.Errorf("failed on page %d (page token %q): %v", page, pageToken, err)
 			}
 
-			parts := strings.Split(name, ".")
-			count := 2 // default to the last two parts
-			for suffix, overrideCount := range overrides {
-				if strings.HasSuffix(name, suffix) {
-					count = overrideCount
-					break
+			var services []Service
+			for _, resp := range batch {
+				svc := serviceFromMessage(resp, projectID)
+				if svc.State == "" {
+					svc.State = state
 				}
-			}
-			if len(parts) >= count {
-				svc["domain"] = strings.Join(parts[len(parts)-count:], ".")
+				services = append(services, svc)
+				// If we've already seen this service, skip it.
+				if _, exists := servicesMap[svc.Name]; exists {
+					continue
+				}
+				servicesMap[svc.Name] = svc
 			}
 
-			if summary := resp.Config.Documentation.Summary; summary != "" {
-				svc["documentation"] = summary
+			done := err != nil || nextPageToken == ""
+			if done {
+				nextPageToken = ""
 			}
-
-			servicesMap[name] = svc
+			if err := checkpoint.saveServicePage(filter, page, services, nextPageToken); err != nil {
+				log.Printf("Warning: failed to checkpoint %s page %d: %v", filter, page, err)
+			}
+			if done {
+				// Break out if iteration is done.
+				return nil
+			}
+			pageToken = nextPageToken
 		}
-		return nil
 	}
 
-	// First call: get enabled services.
-	if err := callAPI("state:ENABLED"); err != nil {
-		return fmt.Errorf("failed to get enabled services: %v", err)
+	// Enabled services are listed first, followed by disabled services.
+	filters := []string{"state:ENABLED", "state:DISABLED"}
+	for _, filter := range filters {
+		if err := callAPI(filter); err != nil {
+			return nil, fmt.Errorf("failed to get services with filter %q: %v", filter, err)
+		}
 	}
 
-	// Second call: get disabled services.
-	if err := callAPI("state:DISABLED"); err != nil {
-		return fmt.Errorf("failed to get disabled services: %v", err)
+	// Create a slice from the map.
+	catalog := &ServiceCatalog{
+		CrawledAt: time.Now().UTC(),
+		Source: CatalogSource{
+			API:     serviceUsageAPI,
+			Project: projectID,
+			Filters: filters,
+		},
+	}
+	var names []string
+	for name, svc := range servicesMap {
+		catalog.Services = append(catalog.Services, svc)
+		names = append(names, name)
 	}
 
-	// Create a slice from the map.
-	var services []map[string]any
-	for _, svc := range servicesMap {
-		services = append(services, svc)
+	if err := checkShrinkage("services.json", previousServiceNames(previousPath), names, maxShrink); err != nil {
+		return nil, err
 	}
 
-	// Sort the slice by the "name" field.
-	sort.Slice(services, func(i, j int) bool {
-		return services[i]["name"].(string) < services[j]["name"].(string)
+	// Sort the services by name.
+	sort.Slice(catalog.Services, func(i, j int) bool {
+		return catalog.Services[i].Name < catalog.Services[j].Name
 	})
 
-	jsonData, err := json.MarshalIndent(services, "", "  ")
+	if history != nil {
+		history.MarkServices(catalog.Services, historyDate(catalog.CrawledAt))
+	}
+
+	if err := writeServiceCatalog(path, catalog); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Service catalog saved to %s\n", path)
+	return catalog, nil
+}
+
+// defaultDiscoveryURL is the Discovery API URL for listing all available APIs.
+const defaultDiscoveryURL = "https://www.googleapis.com/discovery/v1/apis"
+
+// crawlAPIDirectory fetches the Google API Directory and writes it to path, which is
+// checked for shrinkage against the published directory.json at previousPath.
+// It returns the APIs in the directory.
+// When a history is given, each API is stamped with its first and last seen dates.
+func crawlAPIDirectory(ctx context.Context, client *http.Client, url, path, previousPath string, maxShrink float64, history *SeenHistory) ([]APIEntry, error) {
+	body, err := fetchURL(ctx, client, url)
 	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to fetch API directory: %v", err)
 	}
 
-	if err := os.WriteFile("services.json", jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to write services.json: %v", err)
+	// Parse the response into our struct
+	var directory DirectoryList
+	if err := json.Unmarshal(body, &directory); err != nil {
+		return nil, fmt.Errorf("failed to parse API directory JSON: %v", err)
 	}
 
-	fmt.Println("Service catalog saved to services.json")
-	return nil
+	var ids []string
+	for _, api := range directory.Items {
+		ids = append(ids, api.ID)
+	}
+	if err := checkShrinkage("directory.json", previousAPIIDs(previousPath), ids, maxShrink); err != nil {
+		return nil, err
+	}
+
+	if history != nil {
+		history.MarkAPIs(directory.Items, historyDate(time.Now()))
+	}
+
+	// Pretty print the JSON to a file
+	jsonData, err := json.MarshalIndent(directory, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal directory JSON: %v", err)
+	}
+
+	if err := writeFileAtomic(path, jsonData, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	fmt.Printf("API directory saved to %s\n", path)
+	return directory.Items, nil
 }
 
-// crawlAPIDirectory fetches the Google API Directory and writes it to directory.json.
-func crawlAPIDirectory() error {
-	// The Discovery API URL for listing all available APIs
-	url := "https://www.googleapis.com/discovery/v1/apis"
+// newCrawlHTTPClient returns the client for the directory and discovery document requests.
+// Besides http and https it reads file:// URLs, so a crawl can run from local copies.
+// Requests are rate limited and, when opts.HTTPCacheDir is set, revalidated against
+// the cache, which is returned so it can be pruned after the crawl.
+func newCrawlHTTPClient(opts crawlOptions) (*http.Client, *httpCache, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
 
-	// Create an HTTP client
-	client := &http.Client{}
+	client := &http.Client{
+		Transport: newRateLimitedTransport(opts.HTTPRate, transport),
+		Timeout:   opts.HTTPTimeout,
+	}
+	if opts.HTTPCacheDir == "" {
+		return client, nil, nil
+	}
+	cache, err := newHTTPCache(opts.HTTPCacheDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	client.Transport = &cachingTransport{cache: cache, next: client.Transport}
+	return client, cache, nil
+}
 
+// fetchURL performs a GET request and returns the response body, treating any
+// status other than 200 OK as an error. Network errors, rate limiting and server
+// errors are retried with jittered exponential backoff.
+func fetchURL(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		body, retryable, err := fetchURLOnce(ctx, client, url)
+		if err == nil || !retryable {
+			return body, err
+		}
+		if attempt == maxRetryAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+
+		delay := withJitter(backoff)
+		log.Printf("Retrying %s in %s (attempt %d/%d): %v", url, delay, attempt, maxRetryAttempts, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// fetchURLOnce makes a single GET request and reports whether a failure can be retried.
+func fetchURLOnce(ctx context.Context, client *http.Client, url string) ([]byte, bool, error) {
 	// Create a new request
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request for API directory: %v", err)
+		return nil, false, fmt.Errorf("failed to create request for %s: %v", url, err)
 	}
 
 	// Execute the request
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to fetch API directory: %v", err)
+		// A host that does not exist, or a request a replay has no fixture for, will not
+		// appear by retrying.
+		var dnsErr *net.DNSError
+		if (errors.As(err, &dnsErr) && dnsErr.IsNotFound) || errors.Is(err, errNoFixture) {
+			return nil, false, err
+		}
+		return nil, ctx.Err() == nil, err
 	}
 	defer resp.Body.Close()
 
 	// Check the response status
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API directory request failed with status %d: %s", resp.StatusCode, body)
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retryable, fmt.Errorf("request for %s failed with status %d: %s", url, resp.StatusCode, body)
 	}
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read API directory response: %v", err)
+		return nil, ctx.Err() == nil, fmt.Errorf("failed to read response from %s: %v", url, err)
 	}
-
-	// Parse the response into our struct
-	var directory DirectoryList
-	if err := json.Unmarshal(body, &directory); err != nil {
-		return fmt.Errorf("failed to parse API directory JSON: %v", err)
-	}
-
-	// Pretty print the JSON to a file
-	jsonData, err := json.MarshalIndent(directory, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal directory JSON: %v", err)
-	}
-
-	if err := os.WriteFile("directory.json", jsonData, 0644); err != nil {
-		return fmt.Errorf("failed to write directory.json: %v", err)
-	}
-
-	fmt.Println("API directory saved to directory.json")
-	return nil
+	return body, false, nil
 }
 
 // generateHTML reads services.json and produces HTML pages.
 // Domain detail pages are written into the "domain" subfolder
 // and service detail pages into the "service" subfolder.
 func generateHTML() error {
-	// Read and unmarshal services.json.
-	data, err := os.ReadFile("services.json")
+	// Read services.json, migrating older formats to the current schema.
+	catalog, err := readServiceCatalog("services.json")
 	if err != nil {
 		return fmt.Errorf("failed to read services.json: %v", err)
 	}
-
-	var services []Service
-	if err := json.Unmarshal(data, &services); err != nil {
-		return fmt.Errorf("failed to unmarshal JSON: %v", err)
-	}
+	services := catalog.Services
 
 	// For each service, compute Domain (if missing) and a sanitized FileName.
 	for i, svc := range services {
 		if svc.Domain == "" {
-			parts := strings.Split(svc.Name, ".")
-			if len(parts) > 1 {
-				services[i].Domain = strings.Join(parts[len(parts)-2:], ".")
-			} else {
-				services[i].Domain = "misc"
-			}
+			services[i].Domain = serviceDomain(svc.Name)
 		}
 		// Create a file-safe name (e.g., replace "/" with "-").
 		services[i].FileName = strings.ReplaceAll(svc.Name, "/", "-")
 	}
 
+	// Load in all of the APIs from the directory.json file
+	directory, err := readDirectory("directory.json")
+	if err != nil {
+		log.Printf("Warning: Failed to read directory.json: %v", err)
+		// Continue
+		directory = &DirectoryList{}
+	}
+
+	// Sort the APIs by ID
+	sort.Slice(directory.Items, func(i, j int) bool {
+		return directory.Items[i].ID < directory.Items[j].ID
+	})
+
+	// Compute a safe FileName for each API and load its discovery document, if there is one.
+	for i, api := range directory.Items {
+		directory.Items[i].FileName = apiFileID(api.ID)
+
+		doc, err := readDiscoveryDocument(api.ID)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Warning: Failed to read discovery document for %s: %v", api.ID, err)
+			}
+			continue
+		}
+		directory.Items[i].Discovery = doc
+	}
+
+	// Join the services with the APIs they serve.
+	overrides, err := readServiceAPIOverrides(serviceAPIOverridesFile)
+	if err != nil {
+		log.Printf("Warning: Failed to read %s: %v", serviceAPIOverridesFile, err)
+	}
+	linkServicesAndAPIs(services, directory.Items, overrides)
+
 	// Group services by domain.
 	domainMap := make(map[string][]Service)
 	for _, svc := range services {
@@ -326,24 +1009,6 @@ func generateHTML() error {
 	}
 	sort.Strings(domains)
 
-	// Load in all of the APIs from the directory.json file
-	directoryData, err := os.ReadFile("directory.json")
-	if err != nil {
-		log.Printf("Warning: Failed to read directory.json: %v", err)
-		// Continue
-	}
-
-	var directory DirectoryList
-	if err := json.Unmarshal(directoryData, &directory); err != nil {
-		log.Printf("Warning: Failed to parse directory.json: %v", err)
-		// Continue
-	}
-
-	// Sort the APIs by ID
-	sort.Slice(directory.Items, func(i, j int) bool {
-		return directory.Items[i].ID < directory.Items[j].ID
-	})
-
 	// Ensure output directories exist.
 	htmlDir := "html"
 	domainDir := filepath.Join(htmlDir, "domain")
@@ -367,9 +1032,25 @@ func generateHTML() error {
 		log.Fatalf("Error copying style.css: %v", err)
 	}
 
+	// Read the crawl metadata so every page can show when the data was crawled.
+	metadata, err := readCrawlMetadata(crawlMetadataFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to read %s: %v", crawlMetadataFile, err)
+	}
+
 	// Create a template function map with the urlSafe function
 	funcMap := template.FuncMap{
-		"urlize": urlSafe,
+		"urlize":     urlSafe,
+		"schemaType": schemaType,
+		"schemaLink": schemaLink,
+		"scopeFile":  scopeFileName,
+		"feedFile":   feedFileName,
+		"dataAsOf": func() string {
+			if metadata == nil {
+				return ""
+			}
+			return metadata.DataAsOf()
+		},
 	}
 
 	// Parse all external templates with the function map.
@@ -384,9 +1065,11 @@ func generateHTML() error {
 	homeData := struct {
 		TotalServices int
 		TotalApis     int
+		Metadata      *CrawlMetadata
 	}{
 		TotalServices: len(services),
 		TotalApis:     len(directory.Items),
+		Metadata:      metadata,
 	}
 	homeFile := filepath.Join(htmlDir, "index.html")
 	homeOut, err := os.Create(homeFile)
@@ -529,10 +1212,7 @@ func generateHTML() error {
 	// -----------------------------------
 	// Generate individual API pages for each API in the directory file placing it in the "api" html folder
 	for _, api := range directory.Items {
-		// Create a safe filename by replacing the colon with underscore in the ID
-		safeID := strings.ReplaceAll(api.ID, ":", "_")
-
-		apiFileName := fmt.Sprintf("%s.html", safeID)
+		apiFileName := fmt.Sprintf("%s.html", api.FileName)
 		apiFilePath := filepath.Join(apiDir, apiFileName)
 
 		f, err := os.Create(apiFilePath)
@@ -549,6 +1229,40 @@ func generateHTML() error {
 
 		f.Close()
 		log.Printf("Generated API page for %s: %s", api.ID, apiFilePath)
+
+		// Generate the resource and method pages in the API's own folder.
+		if err := generateAPIReference(tmpl, apiDir, api); err != nil {
+			log.Printf("Failed to generate API reference pages for %s: %v", api.ID, err)
+		}
+	}
+
+	// -----------------------------------
+	// 8. Generate the OAuth scope pages
+	// -----------------------------------
+	if err := generateScopePages(tmpl, htmlDir, directory.Items); err != nil {
+		log.Printf("Failed to generate scope pages: %v", err)
+	}
+
+	// -----------------------------------
+	// 9. Generate the coverage report
+	// -----------------------------------
+	if err := generateCoveragePages(tmpl, htmlDir, services, directory.Items); err != nil {
+		log.Printf("Failed to generate coverage report: %v", err)
+	}
+
+	// -----------------------------------
+	// 10. Generate the changelog pages from the crawl history
+	// -----------------------------------
+	changelog, err := generateChangelogPages(tmpl, htmlDir, services, directory.Items)
+	if err != nil {
+		log.Printf("Failed to generate changelog pages: %v", err)
+	}
+
+	// -----------------------------------
+	// 11. Generate the Atom feeds of the changelog
+	// -----------------------------------
+	if err := generateFeeds(htmlDir, changelog, domains); err != nil {
+		log.Printf("Failed to generate feeds: %v", err)
 	}
 
 	// Generate sitemap.xml and robots.txt
@@ -589,6 +1303,33 @@ func copyFile(source, destination string) error {
 	return err
 }
 
+// writeFileAtomic writes data to a temporary file next to path and renames it into place,
+// so an interrupted write never leaves a partial file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it has been renamed.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // generateSitemap creates sitemap.xml based on the generated HTML files ---
 func generateSitemap(htmlDir string) error {
 	// Retrieve the WEBSITE environment variable.
@@ -607,8 +1348,17 @@ func generateSitemap(htmlDir string) error {
 			return err
 		}
 
-		// Skip directories.
+		// Skip directories, leaving out the method and schema pages of every API as
+		// there are too many of them for one sitemap. They are linked from the API pages.
 		if info.IsDir() {
+			relPath, err := filepath.Rel(htmlDir, path)
+			if err != nil {
+				return err
+			}
+			if parts := strings.Split(filepath.ToSlash(relPath), "/"); len(parts) == 3 && parts[0] == "api" &&
+				(parts[2] == "method" || parts[2] == "schema") {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// crawlMetadataFile records how and when the data files were last crawled.
+const crawlMetadataFile = "crawl-metadata.json"
+
+// dataAsOfFormat is the layout used to show when the data was crawled.
+const dataAsOfFormat = "2006-01-02 15:04 UTC"
+
+// CrawlMetadata describes the provenance of the most recent crawl.
+type CrawlMetadata struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Duration is the human readable form of DurationSeconds.
+	Duration        string         `json:"duration"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Project         string         `json:"project,omitempty"`
+	Filters         []string       `json:"filters,omitempty"`
+	Counts          CrawlCounts    `json:"counts"`
+	Sources         []SourceStatus `json:"sources"`
+	Build           BuildMetadata  `json:"build"`
+	// Refreshed maps the services refreshed with -only since the crawl to when they were.
+	Refreshed map[string]time.Time `json:"refreshed,omitempty"`
+}
+
+// CrawlCounts holds the number of entries in the data files after a crawl, including
+// the files kept from an earlier crawl when a source failed.
+type CrawlCounts struct {
+	Services           int `json:"services"`
+	APIs               int `json:"apis"`
+	DiscoveryDocuments int `json:"discoveryDocuments"`
+}
+
+// SourceStatus records the outcome of crawling a single source.
+type SourceStatus struct {
+	Name      string       `json:"name"`
+	Output    SourceOutput `json:"output"`
+	Succeeded bool         `json:"succeeded"`
+	Error     string       `json:"error,omitempty"`
+	// Count is the number of entries the source returned in this crawl.
+	Count    int    `json:"count"`
+	Duration string `json:"duration"`
+	// UpdatedAt is when a crawl last published the output, which is earlier than this
+	// crawl when the source, or any other source of the crawl, failed.
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// BuildMetadata identifies the binary that ran the crawl.
+type BuildMetadata struct {
+	GoVersion    string `json:"goVersion,omitempty"`
+	Path         string `json:"path,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Revision     string `json:"revision,omitempty"`
+	RevisionTime string `json:"revisionTime,omitempty"`
+	Modified     bool   `json:"modified,omitempty"`
+}
+
+// Failed returns the names of the sources that failed.
+func (m *CrawlMetadata) Failed() []string {
+	var failed []string
+	for _, source := range m.Sources {
+		if !source.Succeeded {
+			failed = append(failed, source.Name)
+		}
+	}
+	return failed
+}
+
+// DataAsOf returns when the last successful source refreshed its output, formatted for
+// display, or "" when no source has been refreshed.
+func (m *CrawlMetadata) DataAsOf() string {
+	var latest time.Time
+	for _, source := range m.Sources {
+		if source.UpdatedAt.After(latest) {
+			latest = source.UpdatedAt
+		}
+	}
+	if latest.IsZero() {
+		return ""
+	}
+	return latest.UTC().Format(dataAsOfFormat)
+}
+
+// setUpdatedAt stamps the sources with the time the crawl finished when their output was
+// published, and otherwise carries the time it last was over from the previous crawl,
+// along with the services refreshed since.
+func (m *CrawlMetadata) setUpdatedAt(previous *CrawlMetadata, published bool) {
+	if !published && previous != nil {
+		m.Refreshed = previous.Refreshed
+	}
+	for i, source := range m.Sources {
+		if published && source.Succeeded {
+			m.Sources[i].UpdatedAt = m.FinishedAt
+			continue
+		}
+		if previous == nil {
+			continue
+		}
+		for _, old := range previous.Sources {
+			if old.Name == source.Name {
+				m.Sources[i].UpdatedAt = old.UpdatedAt
+			}
+		}
+	}
+}
+
+// newSourceStatus records the outcome of a source that started at start.
+func newSourceStatus(source Source, start time.Time, count int, err error) SourceStatus {
+	status := SourceStatus{
+		Name:      source.Name(),
+		Output:    source.Output(),
+		Succeeded: err == nil,
+		Count:     count,
+		Duration:  time.Since(start).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// currentBuildMetadata reads the build information embedded in the running binary.
+func currentBuildMetadata() BuildMetadata {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildMetadata{}
+	}
+
+	build := BuildMetadata{
+		GoVersion: info.GoVersion,
+		Path:      info.Main.Path,
+		Version:   info.Main.Version,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.RevisionTime = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+// currentCrawlCounts counts the entries in the data files in dir.
+func currentCrawlCounts(dir string) CrawlCounts {
+	var counts CrawlCounts
+	if catalog, err := readServiceCatalog(filepath.Join(dir, "services.json")); err == nil {
+		counts.Services = len(catalog.Services)
+	}
+	if directory, err := readDirectory(filepath.Join(dir, "directory.json")); err == nil {
+		counts.APIs = len(directory.Items)
+	}
+	if paths, err := filepath.Glob(filepath.Join(dir, discoveryDir, "*.json")); err == nil {
+		counts.DiscoveryDocuments = len(paths)
+	}
+	return counts
+}
+
+// readCrawlMetadata reads the metadata written by the last crawl.
+func readCrawlMetadata(path string) (*CrawlMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var metadata CrawlMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &metadata, nil
+}
+
+// writeCrawlMetadata writes the crawl metadata.
+func writeCrawlMetadata(path string, metadata *CrawlMetadata) error {
+	jsonData, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal crawl metadata: %v", err)
+	}
+	if err := writeFileAtomic(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	serviceusage "cloud.google.com/go/serviceusage/apiv1"
+	serviceusagepb "cloud.google.com/go/serviceusage/apiv1/serviceusagepb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// batchGetServicesLimit is the largest number of services BatchGetServices accepts.
+const batchGetServicesLimit = 30
+
+// parseServiceNames splits a comma-separated list of service names, dropping duplicates.
+func parseServiceNames(list string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// refreshCatalog refreshes the services named in opts.Only in services.json. Unlike a
+// crawl it does not use the checkpoint, record a snapshot or notify the webhooks, and it
+// adds the time of the refresh to the metadata of the last crawl instead of replacing it.
+// It refuses to run while an unfinished crawl is checkpointed, as publishing that crawl
+// would overwrite the refreshed services with the ones it staged.
+func refreshCatalog(ctx context.Context, opts crawlOptions) error {
+	if _, err := os.Stat(filepath.Join(checkpointDir, checkpointFile)); err == nil {
+		return fmt.Errorf("an unfinished crawl is checkpointed in %s, finish it with -crawl -resume or remove it before refreshing services", checkpointDir)
+	}
+
+	history, err := readSeenHistory(seenHistoryFile)
+	if err != nil {
+		log.Printf("Warning: failed to read %s, seen dates will not be updated: %v", seenHistoryFile, err)
+	}
+
+	env := &crawlEnv{
+		Options:             opts,
+		Client:              &http.Client{Transport: http.DefaultTransport},
+		ServiceUsageOptions: serviceUsageClientOptions(opts.ServiceUsageEndpoint, opts.ServiceUsageInsecure),
+		History:             history,
+	}
+	if err := setupFixtures(env); err != nil {
+		return err
+	}
+
+	refreshedAt := time.Now().UTC()
+	if _, err := refreshServices(ctx, env.ServiceUsageOptions, opts.Only, history); err != nil {
+		return err
+	}
+	if history != nil {
+		if err := writeSeenHistory(seenHistoryFile, history); err != nil {
+			return err
+		}
+	}
+
+	metadata, err := readCrawlMetadata(crawlMetadataFile)
+	if os.IsNotExist(err) {
+		metadata, err = &CrawlMetadata{}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", crawlMetadataFile, err)
+	}
+	if metadata.Refreshed == nil {
+		metadata.Refreshed = make(map[string]time.Time)
+	}
+	for _, name := range opts.Only {
+		metadata.Refreshed[name] = refreshedAt
+	}
+	metadata.Counts = currentCrawlCounts(".")
+	return writeCrawlMetadata(crawlMetadataFile, metadata)
+}
+
+// refreshServices fetches the named services from the Service Usage API and merges them
+// into services.json, leaving every other service as it was. Nothing is written unless
+// every service was found. It returns the number of services refreshed.
+func refreshServices(ctx context.Context, clientOpts []option.ClientOption, names []string, history *SeenHistory) (int, error) {
+	catalog, err := readServiceCatalog("services.json")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read services.json to merge into: %v", err)
+	}
+
+	client, err := serviceusage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create service usage client: %v", err)
+	}
+	defer client.Close()
+
+	projectID := os.Getenv("GCP_PROJECT_ID")
+	if projectID == "" {
+		return 0, fmt.Errorf("GCP_PROJECT_ID environment variable is required")
+	}
+
+	var refreshed []Service
+	for start := 0; start < len(names); start += batchGetServicesLimit {
+		end := min(start+batchGetServicesLimit, len(names))
+		services, err := batchGetServices(ctx, client, projectID, names[start:end])
+		if err != nil {
+			return 0, err
+		}
+		refreshed = append(refreshed, services...)
+	}
+
+	if history != nil {
+		history.MarkServices(refreshed, historyDate(time.Now()))
+	}
+
+	byName := make(map[string]int, len(catalog.Services))
+	for i, svc := range catalog.Services {
+		byName[svc.Name] = i
+	}
+	for _, svc := range refreshed {
+		if i, ok := byName[svc.Name]; ok {
+			catalog.Services[i] = svc
+		} else {
+			log.Printf("Adding %s, which was not in services.json", svc.Name)
+			catalog.Services = append(catalog.Services, svc)
+		}
+	}
+	sort.Slice(catalog.Services, func(i, j int) bool {
+		return catalog.Services[i].Name < catalog.Services[j].Name
+	})
+
+	if err := writeServiceCatalog("services.json", catalog); err != nil {
+		return 0, err
+	}
+	fmt.Printf("Refreshed %d services in services.json\n", len(refreshed))
+	return len(refreshed), nil
+}
+
+// batchGetServices fetches up to batchGetServicesLimit services in one request. When the
+// batch is rejected, each service is fetched on its own to find the ones that failed.
+func batchGetServices(ctx context.Context, client *serviceusage.Client, projectID string, names []string) ([]Service, error) {
+	parent := fmt.Sprintf("projects/%s", projectID)
+	req := &serviceusagepb.BatchGetServicesRequest{Parent: parent}
+	for _, name := range names {
+		req.Names = append(req.Names, parent+"/services/"+name)
+	}
+
+	var resp *serviceusagepb.BatchGetServicesResponse
+	err := withRetry(ctx, fmt.Sprintf("batch of %d services", len(names)), func() error {
+		var err error
+		resp, err = client.BatchGetServices(ctx, req)
+		return err
+	})
+	if err == nil {
+		if len(resp.GetServices()) != len(names) {
+			return nil, fmt.Errorf("got %d of the %d services %s", len(resp.GetServices()), len(names), strings.Join(names, ", "))
+		}
+		var services []Service
+		for _, svc := range resp.GetServices() {
+			services = append(services, serviceFromMessage(svc, projectID))
+		}
+		return services, nil
+	}
+	if ctx.Err() != nil || (status.Code(err) != codes.NotFound && status.Code(err) != codes.InvalidArgument) {
+		return nil, fmt.Errorf("failed to get services %s: %v", strings.Join(names, ", "), err)
+	}
+
+	log.Printf("Batch of %d services rejected, fetching them one at a time: %v", len(names), err)
+	var services []Service
+	var failed []string
+	for _, name := range names {
+		var svc *serviceusagepb.Service
+		err := withRetry(ctx, name, func() error {
+			var err error
+			svc, err = client.GetService(ctx, &serviceusagepb.GetServiceRequest{Name: parent + "/services/" + name})
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to get service %s: %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		services = append(services, serviceFromMessage(svc, projectID))
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("failed to get %d of %d services: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return services, nil
+}
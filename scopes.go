@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ScopeEntry represents an OAuth scope and every API that accepts it.
+type ScopeEntry struct {
+	Scope       string     `json:"scope"`
+	Description string     `json:"description,omitempty"`
+	APIs        []ScopeAPI `json:"apis"`
+	// FileName is not saved in JSON; it is computed for linking pages.
+	FileName string `json:"-"`
+}
+
+// ScopeAPI represents an API that accepts a scope and the methods that require it.
+type ScopeAPI struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Methods     []ScopeMethod `json:"methods"`
+	// FileName is not saved in JSON; it is the file name of the API page.
+	FileName string `json:"-"`
+}
+
+// ScopeMethod represents a method that accepts a scope.
+type ScopeMethod struct {
+	ID         string `json:"id"`
+	HTTPMethod string `json:"httpMethod"`
+	// FileName is not saved in JSON; it is the file name of the method page.
+	FileName string `json:"-"`
+}
+
+// unsafeScopeChars matches the characters that are replaced in scope file names.
+var unsafeScopeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// scopeFileName returns a file-safe name for a scope page.
+func scopeFileName(scope string) string {
+	name := strings.TrimPrefix(scope, "https://")
+	name = strings.TrimSuffix(name, "/")
+	return unsafeScopeChars.ReplaceAllString(name, "_")
+}
+
+// buildScopeIndex collects the scopes used by the discovery documents of all APIs,
+// along with the APIs and methods that accept each one, sorted by scope.
+func buildScopeIndex(apis []APIEntry) []ScopeEntry {
+	index := make(map[string]*ScopeEntry)
+	for _, api := range apis {
+		if api.Discovery == nil {
+			continue
+		}
+
+		// Group the methods of the API by the scopes they accept.
+		methodsByScope := make(map[string][]ScopeMethod)
+		for _, resource := range api.Discovery.FlatResources() {
+			for _, method := range resource.Methods {
+				for _, scope := range method.Scopes {
+					methodsByScope[scope] = append(methodsByScope[scope], ScopeMethod{
+						ID:         method.ID,
+						HTTPMethod: method.HTTPMethod,
+						FileName:   method.FileName(),
+					})
+				}
+			}
+		}
+
+		// Methods may list scopes that the auth block does not declare.
+		declared := api.Discovery.Auth.OAuth2.Scopes
+		scopeNames := make([]string, 0, len(declared))
+		for scope := range declared {
+			scopeNames = append(scopeNames, scope)
+		}
+		for scope := range methodsByScope {
+			if _, ok := declared[scope]; !ok {
+				scopeNames = append(scopeNames, scope)
+			}
+		}
+
+		for _, scope := range scopeNames {
+			info := declared[scope]
+			entry, ok := index[scope]
+			if !ok {
+				entry = &ScopeEntry{
+					Scope:    scope,
+					FileName: scopeFileName(scope),
+				}
+				index[scope] = entry
+			}
+			// Prefer the description from the preferred version of an API.
+			if entry.Description == "" || (api.Preferred && info.Description != "") {
+				entry.Description = info.Description
+			}
+
+			methods := methodsByScope[scope]
+			sort.Slice(methods, func(i, j int) bool {
+				return methods[i].ID < methods[j].ID
+			})
+			entry.APIs = append(entry.APIs, ScopeAPI{
+				ID:          api.ID,
+				Title:       api.Title,
+				Description: info.Description,
+				Methods:     methods,
+				FileName:    api.FileName,
+			})
+		}
+	}
+
+	scopes := make([]ScopeEntry, 0, len(index))
+	for _, entry := range index {
+		sort.Slice(entry.APIs, func(i, j int) bool {
+			return entry.APIs[i].ID < entry.APIs[j].ID
+		})
+		scopes = append(scopes, *entry)
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		return scopes[i].Scope < scopes[j].Scope
+	})
+	return scopes
+}
+
+// generateScopePages writes the scopes index page, a page per scope in the scope
+// folder and the same data as scopes.json.
+func generateScopePages(tmpl *template.Template, htmlDir string, apis []APIEntry) error {
+	scopes := buildScopeIndex(apis)
+
+	scopesData := struct {
+		Scopes []ScopeEntry `json:"scopes"`
+	}{
+		Scopes: scopes,
+	}
+	scopesFile := filepath.Join(htmlDir, "scopes.html")
+	if err := renderTemplate(tmpl, "scopes.html", scopesFile, scopesData); err != nil {
+		return err
+	}
+	log.Printf("Generated scopes page: %s", scopesFile)
+
+	scopeDir := filepath.Join(htmlDir, "scope")
+	if err := os.MkdirAll(scopeDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create scope directory: %v", err)
+	}
+	for _, scope := range scopes {
+		scopeFile := filepath.Join(scopeDir, scope.FileName+".html")
+		if err := renderTemplate(tmpl, "scope.html", scopeFile, scope); err != nil {
+			return err
+		}
+	}
+	log.Printf("Generated %d scope pages in %s", len(scopes), scopeDir)
+
+	jsonData, err := json.MarshalIndent(scopesData, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal scopes JSON: %v", err)
+	}
+	jsonFile := filepath.Join(htmlDir, "scopes.json")
+	if err := writeFileAtomic(jsonFile, jsonData, 0644); err != nil {
+		return fmt.Errorf("failed to write scopes.json: %v", err)
+	}
+	log.Printf("Generated scopes data: %s", jsonFile)
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"strings"
+
+	serviceusagepb "cloud.google.com/go/serviceusage/apiv1/serviceusagepb"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/genproto/googleapis/api/serviceconfig"
+	"google.golang.org/protobuf/types/known/apipb"
+)
+
+// ServiceAPI represents a protocol buffer interface exposed by a service.
+type ServiceAPI struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+	Mixins  []string `json:"mixins,omitempty"`
+}
+
+// ServiceEndpoint represents a network endpoint that serves a service.
+type ServiceEndpoint struct {
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases,omitempty"`
+	Target    string   `json:"target,omitempty"`
+	AllowCORS bool     `json:"allowCors,omitempty"`
+}
+
+// ServiceAuthentication represents the authentication rules and providers of a service.
+type ServiceAuthentication struct {
+	Rules     []AuthenticationRule `json:"rules,omitempty"`
+	Providers []AuthProvider       `json:"providers,omitempty"`
+}
+
+// AuthenticationRule represents the authentication requirements for the selected methods.
+type AuthenticationRule struct {
+	Selector               string   `json:"selector"`
+	OAuthScopes            []string `json:"oauthScopes,omitempty"`
+	AllowWithoutCredential bool     `json:"allowWithoutCredential,omitempty"`
+	Providers              []string `json:"providers,omitempty"`
+}
+
+// AuthProvider represents a JWT authentication provider trusted by a service.
+type AuthProvider struct {
+	ID        string `json:"id"`
+	Issuer    string `json:"issuer,omitempty"`
+	JwksURI   string `json:"jwksUri,omitempty"`
+	Audiences string `json:"audiences,omitempty"`
+}
+
+// ServiceUsage represents the requirements a consumer must meet to use a service.
+type ServiceUsage struct {
+	Requirements                []string    `json:"requirements,omitempty"`
+	Rules                       []UsageRule `json:"rules,omitempty"`
+	ProducerNotificationChannel string      `json:"producerNotificationChannel,omitempty"`
+}
+
+// UsageRule represents the usage settings for the selected methods.
+type UsageRule struct {
+	Selector               string `json:"selector"`
+	AllowUnregisteredCalls bool   `json:"allowUnregisteredCalls,omitempty"`
+	SkipServiceControl     bool   `json:"skipServiceControl,omitempty"`
+}
+
+// MonitoredResource represents a monitored resource type defined by a service.
+type MonitoredResource struct {
+	Type        string            `json:"type"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Description string            `json:"description,omitempty"`
+	LaunchStage string            `json:"launchStage,omitempty"`
+	Labels      []LabelDescriptor `json:"labels,omitempty"`
+}
+
+// LabelDescriptor represents a label that identifies a monitored resource.
+type LabelDescriptor struct {
+	Key         string `json:"key"`
+	ValueType   string `json:"valueType,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ServiceMonitoring represents where the metrics of a service are sent.
+type ServiceMonitoring struct {
+	ProducerDestinations []MonitoringDestination `json:"producerDestinations,omitempty"`
+	ConsumerDestinations []MonitoringDestination `json:"consumerDestinations,omitempty"`
+}
+
+// MonitoringDestination represents the metrics sent for a monitored resource type.
+type MonitoringDestination struct {
+	MonitoredResource string   `json:"monitoredResource"`
+	Metrics           []string `json:"metrics,omitempty"`
+}
+
+// serviceFromConfig converts a service configuration returned by the Service Usage API.
+func serviceFromConfig(cfg *serviceusagepb.ServiceConfig) Service {
+	return Service{
+		Name:               cfg.GetName(),
+		Title:              cfg.GetTitle(),
+		Documentation:      cfg.GetDocumentation().GetSummary(),
+		Domain:             nameDomain(cfg.GetName()),
+		APIs:               convertAPIs(cfg.GetApis()),
+		Endpoints:          convertEndpoints(cfg.GetEndpoints()),
+		Authentication:     convertAuthentication(cfg.GetAuthentication()),
+		Usage:              convertUsage(cfg.GetUsage()),
+		MonitoredResources: convertMonitoredResources(cfg.GetMonitoredResources()),
+		Monitoring:         convertMonitoring(cfg.GetMonitoring()),
+	}
+}
+
+// serviceFromMessage converts a service returned by the Service Usage API for a project,
+// keeping the state it is in there.
+func serviceFromMessage(msg *serviceusagepb.Service, project string) Service {
+	svc := serviceFromConfig(msg.GetConfig())
+	if state := msg.GetState(); state != serviceusagepb.State_STATE_UNSPECIFIED {
+		svc.State = state.String()
+	}
+	svc.Project = project
+	return svc
+}
+
+// convertAPIs converts the interfaces exposed by a service.
+func convertAPIs(apis []*apipb.Api) []ServiceAPI {
+	var result []ServiceAPI
+	for _, api := range apis {
+		converted := ServiceAPI{
+			Name:    api.GetName(),
+			Version: api.GetVersion(),
+		}
+		for _, method := range api.GetMethods() {
+			converted.Methods = append(converted.Methods, method.GetName())
+		}
+		for _, mixin := range api.GetMixins() {
+			converted.Mixins = append(converted.Mixins, mixin.GetName())
+		}
+		result = append(result, converted)
+	}
+	return result
+}
+
+// convertEndpoints converts the endpoints that serve a service.
+func convertEndpoints(endpoints []*serviceconfig.Endpoint) []ServiceEndpoint {
+	var result []ServiceEndpoint
+	for _, endpoint := range endpoints {
+		result = append(result, ServiceEndpoint{
+			Name:      endpoint.GetName(),
+			Aliases:   endpoint.GetAliases(),
+			Target:    endpoint.GetTarget(),
+			AllowCORS: endpoint.GetAllowCors(),
+		})
+	}
+	return result
+}
+
+// convertAuthentication converts the authentication section, returning nil when it is empty.
+func convertAuthentication(auth *serviceconfig.Authentication) *ServiceAuthentication {
+	if len(auth.GetRules()) == 0 && len(auth.GetProviders()) == 0 {
+		return nil
+	}
+
+	result := &ServiceAuthentication{}
+	for _, rule := range auth.GetRules() {
+		converted := AuthenticationRule{
+			Selector:               rule.GetSelector(),
+			AllowWithoutCredential: rule.GetAllowWithoutCredential(),
+		}
+		// Canonical scopes are a single comma-separated string.
+		for _, scope := range strings.Split(rule.GetOauth().GetCanonicalScopes(), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				converted.OAuthScopes = append(converted.OAuthScopes, scope)
+			}
+		}
+		for _, requirement := range rule.GetRequirements() {
+			converted.Providers = append(converted.Providers, requirement.GetProviderId())
+		}
+		result.Rules = append(result.Rules, converted)
+	}
+	for _, provider := range auth.GetProviders() {
+		result.Providers = append(result.Providers, AuthProvider{
+			ID:        provider.GetId(),
+			Issuer:    provider.GetIssuer(),
+			JwksURI:   provider.GetJwksUri(),
+			Audiences: provider.GetAudiences(),
+		})
+	}
+	return result
+}
+
+// convertUsage converts the usage section, returning nil when it is empty.
+func convertUsage(usage *serviceconfig.Usage) *ServiceUsage {
+	if len(usage.GetRequirements()) == 0 && len(usage.GetRules()) == 0 && usage.GetProducerNotificationChannel() == "" {
+		return nil
+	}
+
+	result := &ServiceUsage{
+		Requirements:                usage.GetRequirements(),
+		ProducerNotificationChannel: usage.GetProducerNotificationChannel(),
+	}
+	for _, rule := range usage.GetRules() {
+		result.Rules = append(result.Rules, UsageRule{
+			Selector:               rule.GetSelector(),
+			AllowUnregisteredCalls: rule.GetAllowUnregisteredCalls(),
+			SkipServiceControl:     rule.GetSkipServiceControl(),
+		})
+	}
+	return result
+}
+
+// convertMonitoredResources converts the monitored resource types defined by a service.
+func convertMonitoredResources(resources []*monitoredres.MonitoredResourceDescriptor) []MonitoredResource {
+	var result []MonitoredResource
+	for _, resource := range resources {
+		converted := MonitoredResource{
+			Type:        resource.GetType(),
+			DisplayName: resource.GetDisplayName(),
+			Description: resource.GetDescription(),
+		}
+		if stage := resource.GetLaunchStage(); stage != 0 {
+			converted.LaunchStage = stage.String()
+		}
+		for _, label := range resource.GetLabels() {
+			converted.Labels = append(converted.Labels, LabelDescriptor{
+				Key:         label.GetKey(),
+				ValueType:   label.GetValueType().String(),
+				Description: label.GetDescription(),
+			})
+		}
+		result = append(result, converted)
+	}
+	return result
+}
+
+// convertMonitoring converts the monitoring section, returning nil when it is empty.
+func convertMonitoring(monitoring *serviceconfig.Monitoring) *ServiceMonitoring {
+	if len(monitoring.GetProducerDestinations()) == 0 && len(monitoring.GetConsumerDestinations()) == 0 {
+		return nil
+	}
+
+	return &ServiceMonitoring{
+		ProducerDestinations: convertMonitoringDestinations(monitoring.GetProducerDestinations()),
+		ConsumerDestinations: convertMonitoringDestinations(monitoring.GetConsumerDestinations()),
+	}
+}
+
+// convertMonitoringDestinations converts a list of monitoring destinations.
+func convertMonitoringDestinations(destinations []*serviceconfig.Monitoring_MonitoringDestination) []MonitoringDestination {
+	var result []MonitoringDestination
+	for _, destination := range destinations {
+		result = append(result, MonitoringDestination{
+			MonitoredResource: destination.GetMonitoredResource(),
+			Metrics:           destination.GetMetrics(),
+		})
+	}
+	return result
+}
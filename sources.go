@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// Source is a data source the crawler fetches and stores in the repository.
+type Source interface {
+	// Name identifies the source in the -sources flag, the logs and crawl-metadata.json.
+	Name() string
+	// Output describes where the source is stored and in what format.
+	Output() SourceOutput
+	// Fetch crawls the source and writes its output to env.outputPath(Output().Path), from
+	// where the crawl publishes it once every source succeeded. It returns the number of
+	// entries written.
+	Fetch(ctx context.Context, env *crawlEnv) (int, error)
+}
+
+// SourceOutput describes the file or folder a source writes.
+type SourceOutput struct {
+	// Path is relative to the repository root.
+	Path string `json:"path"`
+	// Schema names the format of the output, such as the kind of a Google API response.
+	Schema string `json:"schema"`
+	// SchemaVersion is set when the format is versioned by this repository.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+}
+
+// crawlEnv holds what the sources of a crawl share.
+type crawlEnv struct {
+	Options crawlOptions
+	// Client is shared by the sources that make plain HTTP requests.
+	Client *http.Client
+	// Cache is the HTTP cache behind Client, or nil when it is disabled.
+	Cache *httpCache
+	// ServiceUsageOptions configure the Service Usage client.
+	ServiceUsageOptions []option.ClientOption
+	// History is nil when it could not be read, in which case seen dates are not updated.
+	History *SeenHistory
+	// Checkpoint records the progress of the sources so an unfinished crawl can be resumed.
+	Checkpoint *CrawlCheckpoint
+	// Metadata lets a source record how it was crawled.
+	Metadata *CrawlMetadata
+}
+
+// outputPath returns where the output of a source stored at path is written during the crawl.
+func (env *crawlEnv) outputPath(path string) string {
+	return env.Checkpoint.outputPath(path)
+}
+
+// publishedPath returns where the crawl publishes the file at path, so the previous
+// copy there is what the new output is compared against.
+func (env *crawlEnv) publishedPath(path string) string {
+	return filepath.Join(env.Options.OutputDir, path)
+}
+
+// registeredSources lists every source the crawler knows about, in the order they are
+// crawled. A source that reads the output of another must come after it.
+var registeredSources = []Source{
+	serviceUsageSource{},
+	apiDirectorySource{},
+	discoveryDocumentsSource{},
+}
+
+// sourceNames returns the names of the registered sources.
+func sourceNames() []string {
+	var names []string
+	for _, source := range registeredSources {
+		names = append(names, source.Name())
+	}
+	return names
+}
+
+// selectSources returns the registered sources named in a comma-separated list, in crawl
+// order. An empty list selects every source.
+func selectSources(list string) ([]Source, error) {
+	if strings.TrimSpace(list) == "" {
+		return registeredSources, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			wanted[name] = true
+		}
+	}
+
+	var selected []Source
+	for _, source := range registeredSources {
+		if wanted[source.Name()] {
+			selected = append(selected, source)
+			delete(wanted, source.Name())
+		}
+	}
+	if len(wanted) > 0 {
+		var unknown []string
+		for name := range wanted {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown sources %s, expected some of %s",
+			strings.Join(unknown, ", "), strings.Join(sourceNames(), ", "))
+	}
+	return selected, nil
+}
+
+// serviceUsageSource lists the services of the Service Usage API into services.json.
+type serviceUsageSource struct{}
+
+func (serviceUsageSource) Name() string { return "serviceusage" }
+
+func (serviceUsageSource) Output() SourceOutput {
+	return SourceOutput{Path: "services.json", Schema: "gcp-service-catalog#serviceCatalog", SchemaVersion: servicesSchemaVersion}
+}
+
+func (serviceUsageSource) Fetch(ctx context.Context, env *crawlEnv) (int, error) {
+	catalog, err := crawlServiceUsage(ctx, env.ServiceUsageOptions, env.outputPath("services.json"), env.publishedPath("services.json"), env.Options.MaxShrink, env.History, env.Checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	env.Metadata.Filters = catalog.Source.Filters
+	return len(catalog.Services), nil
+}
+
+// apiDirectorySource fetches the Google API Directory into directory.json.
+type apiDirectorySource struct{}
+
+func (apiDirectorySource) Name() string { return "directory" }
+
+func (apiDirectorySource) Output() SourceOutput {
+	return SourceOutput{Path: "directory.json", Schema: "discovery#directoryList"}
+}
+
+func (apiDirectorySource) Fetch(ctx context.Context, env *crawlEnv) (int, error) {
+	apis, err := crawlAPIDirectory(ctx, env.Client, env.Options.DiscoveryURL, env.outputPath("directory.json"), env.publishedPath("directory.json"), env.Options.MaxShrink, env.History)
+	return len(apis), err
+}
+
+// discoveryDocumentsSource fetches the discovery document of every API in directory.json.
+// It reads the directory crawled by the directory source, or the published one when that
+// source was not selected, which a replay takes from its output rather than the repository.
+type discoveryDocumentsSource struct{}
+
+func (discoveryDocumentsSource) Name() string { return "discovery" }
+
+func (discoveryDocumentsSource) Output() SourceOutput {
+	return SourceOutput{Path: discoveryDir, Schema: "discovery#restDescription"}
+}
+
+func (discoveryDocumentsSource) Fetch(ctx context.Context, env *crawlEnv) (int, error) {
+	path := env.outputPath("directory.json")
+	if _, err := os.Stat(path); err != nil {
+		path = env.publishedPath("directory.json")
+	}
+	directory, err := readDirectory(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the API directory: %v", err)
+	}
+	// Documents saved before the crawl was resumed are not requested again, but their
+	// cache entries are still needed to revalidate them on the next crawl.
+	if env.Cache != nil {
+		for _, api := range directory.Items {
+			if env.Checkpoint.documentFetched(api.ID) {
+				env.Cache.markUsed(api.DiscoveryRestURL)
+			}
+		}
+	}
+	return crawlDiscoveryDocuments(ctx, env.Client, directory.Items, env.outputPath(discoveryDir), env.Options.DiscoveryWorkers, env.Checkpoint)
+}
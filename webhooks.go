@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// webhookTimeout limits how long a single webhook request may take.
+const webhookTimeout = 30 * time.Second
+
+// slackEntryLimit is the largest number of entries listed per section of a Slack message.
+const slackEntryLimit = 25
+
+// Payload formats a webhook can receive.
+const (
+	webhookFormatJSON  = "json"
+	webhookFormatSlack = "slack"
+)
+
+// Webhook is a URL that is notified when a crawl changes the catalog.
+type Webhook struct {
+	URL    string
+	Format string
+}
+
+// WebhookPayload is the generic JSON body posted to webhooks.
+type WebhookPayload struct {
+	Event            string              `json:"event"`
+	CrawledAt        time.Time           `json:"crawledAt"`
+	Summary          string              `json:"summary"`
+	AddedServices    []WebhookEntry      `json:"addedServices"`
+	RemovedServices  []WebhookEntry      `json:"removedServices"`
+	PreferredChanges []WebhookPreference `json:"preferredChanges"`
+}
+
+// WebhookEntry identifies a service in a webhook payload.
+type WebhookEntry struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+// WebhookPreference describes an API that became, or stopped being, the preferred version.
+type WebhookPreference struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Preferred bool   `json:"preferred"`
+}
+
+// parseWebhooks builds the webhooks from comma-separated lists of generic and Slack URLs.
+func parseWebhooks(jsonURLs, slackURLs string) []Webhook {
+	var webhooks []Webhook
+	add := func(urls, format string) {
+		for _, u := range strings.Split(urls, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				webhooks = append(webhooks, Webhook{URL: u, Format: format})
+			}
+		}
+	}
+	add(jsonURLs, webhookFormatJSON)
+	add(slackURLs, webhookFormatSlack)
+	return webhooks
+}
+
+// redactURL hides everything but the scheme and host of a URL, as webhook URLs embed secrets.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "(invalid URL)"
+	}
+	return u.Scheme + "://" + u.Host + "/..."
+}
+
+// newWebhookPayload keeps the changes webhooks are notified about: added and removed
+// services and APIs whose preferred version changed. It returns nil when there are none.
+func newWebhookPayload(crawledAt time.Time, changes []Change) *WebhookPayload {
+	payload := &WebhookPayload{
+		Event:            "catalog.changed",
+		CrawledAt:        crawledAt,
+		AddedServices:    []WebhookEntry{},
+		RemovedServices:  []WebhookEntry{},
+		PreferredChanges: []WebhookPreference{},
+	}
+	for _, change := range changes {
+		switch {
+		case change.Type == changeTypeService && change.Kind == ChangeAdded:
+			payload.AddedServices = append(payload.AddedServices, WebhookEntry{Name: change.ID, Title: change.Title})
+		case change.Type == changeTypeService && change.Kind == ChangeRemoved:
+			payload.RemovedServices = append(payload.RemovedServices, WebhookEntry{Name: change.ID, Title: change.Title})
+		case change.Type == changeTypeAPI && change.Kind == ChangePreferred:
+			payload.PreferredChanges = append(payload.PreferredChanges, WebhookPreference{
+				ID:        change.ID,
+				Title:     change.Title,
+				Preferred: change.New == preferredLabel(true),
+			})
+		}
+	}
+
+	if len(payload.AddedServices) == 0 && len(payload.RemovedServices) == 0 && len(payload.PreferredChanges) == 0 {
+		return nil
+	}
+	payload.Summary = fmt.Sprintf("%d services added, %d services removed, %d preferred API versions changed",
+		len(payload.AddedServices), len(payload.RemovedServices), len(payload.PreferredChanges))
+	return payload
+}
+
+// slackMessage formats a payload as a Slack incoming webhook message.
+func slackMessage(payload *WebhookPayload) map[string]string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*GCP service catalog changed:* %s\n", payload.Summary)
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n*%s*\n", title)
+		for i, line := range lines {
+			if i == slackEntryLimit {
+				fmt.Fprintf(&b, "• and %d more\n", len(lines)-slackEntryLimit)
+				break
+			}
+			fmt.Fprintf(&b, "• %s\n", line)
+		}
+	}
+
+	var added, removed, preferred []string
+	for _, entry := range payload.AddedServices {
+		added = append(added, fmt.Sprintf("`%s` %s", entry.Name, entry.Title))
+	}
+	for _, entry := range payload.RemovedServices {
+		removed = append(removed, fmt.Sprintf("`%s` %s", entry.Name, entry.Title))
+	}
+	for _, entry := range payload.PreferredChanges {
+		state := "is now preferred"
+		if !entry.Preferred {
+			state = "is no longer preferred"
+		}
+		preferred = append(preferred, fmt.Sprintf("`%s` %s", entry.ID, state))
+	}
+	section("New services", added)
+	section("Removed services", removed)
+	section("Preferred API versions", preferred)
+
+	return map[string]string{"text": b.String()}
+}
+
+// notifyCatalogChanges compares the catalog on disk after a crawl with the catalog from
+// before it and notifies the webhooks when anything they report on changed.
+func notifyCatalogChanges(ctx context.Context, before *Snapshot, opts crawlOptions) error {
+	after, err := loadCatalogSnapshot(".")
+	if err != nil {
+		return fmt.Errorf("failed to read the crawled catalog: %v", err)
+	}
+
+	payload := newWebhookPayload(time.Now().UTC(), diffSnapshots(before, after))
+	if payload == nil {
+		log.Printf("No service or preferred API changes, webhooks not notified")
+		return nil
+	}
+	return notifyWebhooks(ctx, opts.Webhooks, payload, opts.WebhookDryRun)
+}
+
+// notifyWebhooks posts the payload to every webhook, or only logs it in dry-run mode.
+// A webhook that still fails after retrying is reported in the returned error.
+func notifyWebhooks(ctx context.Context, webhooks []Webhook, payload *WebhookPayload, dryRun bool) error {
+	client := &http.Client{Timeout: webhookTimeout}
+
+	var failures []string
+	for _, webhook := range webhooks {
+		var body any = payload
+		if webhook.Format == webhookFormatSlack {
+			body = slackMessage(payload)
+		}
+		jsonData, err := json.MarshalIndent(body, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal webhook payload: %v", err)
+		}
+
+		if dryRun {
+			log.Printf("Dry run: would post %s payload to %s:\n%s", webhook.Format, redactURL(webhook.URL), jsonData)
+			continue
+		}
+		if err := postWebhook(ctx, client, webhook.URL, jsonData); err != nil {
+			log.Printf("Failed to notify webhook %s: %v", redactURL(webhook.URL), err)
+			failures = append(failures, redactURL(webhook.URL))
+			continue
+		}
+		log.Printf("Notified webhook %s", redactURL(webhook.URL))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to notify %d webhooks: %s", len(failures), strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// postWebhook posts a JSON body, retrying with exponential backoff on network errors,
+// rate limiting and server errors.
+func postWebhook(ctx context.Context, client *http.Client, webhookURL string, body []byte) error {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := postWebhookOnce(ctx, client, webhookURL, body)
+		if err == nil || !retryable {
+			return err
+		}
+		if attempt == maxRetryAttempts {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+
+		log.Printf("Retrying webhook %s in %s (attempt %d/%d): %v", redactURL(webhookURL), backoff, attempt, maxRetryAttempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// postWebhookOnce makes a single webhook request and reports whether a failure can be retried.
+func postWebhookOnce(ctx context.Context, client *http.Client, webhookURL string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused.
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewWebhookPayload(t *testing.T) {
+	crawledAt := time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		changes []Change
+		want    *WebhookPayload
+	}{
+		{
+			name: "no changes",
+			want: nil,
+		},
+		{
+			name: "only changes webhooks do not report",
+			changes: []Change{
+				{Kind: ChangeRetitled, Type: changeTypeService, ID: "a.googleapis.com", Title: "A", Old: "Old A", New: "A"},
+				{Kind: ChangeAdded, Type: changeTypeAPI, ID: "a:v1", Title: "A API"},
+			},
+			want: nil,
+		},
+		{
+			name: "services and preferred versions",
+			changes: []Change{
+				{Kind: ChangeAdded, Type: changeTypeService, ID: "a.googleapis.com", Title: "A"},
+				{Kind: ChangeRemoved, Type: changeTypeService, ID: "b.googleapis.com", Title: "B"},
+				{Kind: ChangePreferred, Type: changeTypeAPI, ID: "c:v2", Title: "C API", Old: preferredLabel(false), New: preferredLabel(true)},
+				{Kind: ChangePreferred, Type: changeTypeAPI, ID: "c:v1", Title: "C API", Old: preferredLabel(true), New: preferredLabel(false)},
+			},
+			want: &WebhookPayload{
+				Event:           "catalog.changed",
+				CrawledAt:       crawledAt,
+				Summary:         "1 services added, 1 services removed, 2 preferred API versions changed",
+				AddedServices:   []WebhookEntry{{Name: "a.googleapis.com", Title: "A"}},
+				RemovedServices: []WebhookEntry{{Name: "b.googleapis.com", Title: "B"}},
+				PreferredChanges: []WebhookPreference{
+					{ID: "c:v2", Title: "C API", Preferred: true},
+					{ID: "c:v1", Title: "C API", Preferred: false},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newWebhookPayload(crawledAt, tt.changes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newWebhookPayload() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// webhookServer is a local webhook answering each request with the next of its statuses,
+// and 200 once they run out.
+type webhookServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s request with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		s.bodies = append(s.bodies, body)
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requests returns the bodies posted to the server.
+func (s *webhookServer) requests() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bodies
+}
+
+func TestNotifyWebhooks(t *testing.T) {
+	payload := newWebhookPayload(time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC), []Change{
+		{Kind: ChangeAdded, Type: changeTypeService, ID: "a.googleapis.com", Title: "A"},
+	})
+
+	tests := []struct {
+		name     string
+		statuses []int
+		dryRun   bool
+		wantErr  bool
+		// wantPosts is the number of requests the webhook receives.
+		wantPosts int
+	}{
+		{name: "success", wantPosts: 1},
+		{name: "server error is retried", statuses: []int{http.StatusInternalServerError}, wantPosts: 2},
+		{name: "rate limit is retried", statuses: []int{http.StatusTooManyRequests}, wantPosts: 2},
+		{name: "client error is not retried", statuses: []int{http.StatusBadRequest}, wantErr: true, wantPosts: 1},
+		{name: "dry run posts nothing", dryRun: true, wantPosts: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newWebhookServer(t, tt.statuses...)
+			webhooks := []Webhook{{URL: server.URL, Format: webhookFormatJSON}}
+
+			err := notifyWebhooks(context.Background(), webhooks, payload, tt.dryRun)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("notifyWebhooks() error = %v, want error %v", err, tt.wantErr)
+			}
+			bodies := server.requests()
+			if len(bodies) != tt.wantPosts {
+				t.Fatalf("webhook received %d requests, want %d", len(bodies), tt.wantPosts)
+			}
+			for _, body := range bodies {
+				var got WebhookPayload
+				if err := json.Unmarshal(body, &got); err != nil {
+					t.Fatalf("failed to parse the posted payload: %v", err)
+				}
+				if !reflect.DeepEqual(&got, payload) {
+					t.Errorf("posted payload = %+v, want %+v", got, *payload)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyWebhooksSlack(t *testing.T) {
+	server := newWebhookServer(t)
+	payload := newWebhookPayload(time.Now().UTC(), []Change{
+		{Kind: ChangeRemoved, Type: changeTypeService, ID: "b.googleapis.com", Title: "B"},
+	})
+
+	if err := notifyWebhooks(context.Background(), []Webhook{{URL: server.URL, Format: webhookFormatSlack}}, payload, false); err != nil {
+		t.Fatalf("notifyWebhooks() error = %v", err)
+	}
+	bodies := server.requests()
+	if len(bodies) != 1 {
+		t.Fatalf("webhook received %d requests, want 1", len(bodies))
+	}
+	var got map[string]string
+	if err := json.Unmarshal(bodies[0], &got); err != nil {
+		t.Fatalf("failed to parse the posted message: %v", err)
+	}
+	want := "*GCP service catalog changed:* 0 services added, 1 services removed, 0 preferred API versions changed\n" +
+		"\n*Removed services*\n• `b.googleapis.com` B\n"
+	if got["text"] != want {
+		t.Errorf("posted text = %q, want %q", got["text"], want)
+	}
+}